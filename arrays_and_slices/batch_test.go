@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// PushSlice appends as many values as fit and returns how many were
+// accepted, copying at most two segments across the wrap point. In
+// overwrite mode all values are accepted and the oldest ones are evicted.
+func (q *CircularQueue[T]) PushSlice(values []T) int {
+	size := cap(q.values)
+	accepted := len(values)
+	if q.overwrite {
+		if excess := q.sz + len(values) - size; excess > 0 {
+			q.overwrites += excess
+			if len(values) >= size {
+				q.discard(q.sz)
+				q.idx = 0
+				values = values[len(values)-size:]
+			} else {
+				q.discard(excess)
+			}
+		}
+	} else if free := size - q.sz; len(values) > free {
+		values = values[:free]
+		accepted = free
+	}
+	if len(values) == 0 {
+		return accepted
+	}
+
+	tail := (q.idx + q.sz) % size
+	n := copy(q.values[tail:], values)
+	copy(q.values, values[n:])
+	q.sz += len(values)
+	return accepted
+}
+
+// PopInto moves up to len(dst) elements from the front into dst and
+// returns how many were moved.
+func (q *CircularQueue[T]) PopInto(dst []T) int {
+	n := q.CopyTo(dst)
+	q.discard(n)
+	return n
+}
+
+// discard drops count elements from the front, clearing their slots
+// segment by segment.
+func (q *CircularQueue[T]) discard(count int) {
+	if count == 0 {
+		return
+	}
+	var zero T
+	first := q.values[q.idx:]
+	if len(first) > count {
+		first = first[:count]
+	}
+	for i := range first {
+		first[i] = zero
+	}
+	second := q.values[:count-len(first)]
+	for i := range second {
+		second[i] = zero
+	}
+	q.idx = (q.idx + count) % cap(q.values)
+	q.sz -= count
+}
+
+func TestCircularQueuePushSlice(t *testing.T) {
+	queue := NewCircularQueue[int](5)
+
+	assert.Equal(t, 0, queue.PushSlice(nil))
+	assert.Equal(t, 3, queue.PushSlice([]int{1, 2, 3}))
+	assert.Equal(t, []int{1, 2, 3, 0, 0}, queue.values)
+
+	dst := make([]int, 2)
+	assert.Equal(t, 2, queue.PopInto(dst))
+	assert.Equal(t, []int{1, 2}, dst)
+	assert.Equal(t, []int{0, 0, 3, 0, 0}, queue.values)
+
+	// wraps around the end of values
+	assert.Equal(t, 4, queue.PushSlice([]int{4, 5, 6, 7, 8}))
+	assert.Equal(t, []int{6, 7, 3, 4, 5}, queue.values)
+	assert.True(t, queue.Full())
+	assert.Equal(t, 0, queue.PushSlice([]int{9}))
+
+	dst = make([]int, 10)
+	assert.Equal(t, 5, queue.PopInto(dst))
+	assert.Equal(t, []int{3, 4, 5, 6, 7}, dst[:5])
+	assert.True(t, queue.Empty())
+	assert.Equal(t, []int{0, 0, 0, 0, 0}, queue.values)
+	assert.Equal(t, 0, queue.PopInto(dst))
+
+	// an empty ring keeps its index, the next batch wraps again
+	assert.Equal(t, 2, queue.idx)
+	assert.Equal(t, 5, queue.PushSlice([]int{10, 11, 12, 13, 14}))
+	assert.Equal(t, []int{10, 11, 12, 13, 14}, queue.ToSlice())
+
+	empty := NewCircularQueue[int](0)
+	assert.Equal(t, 0, empty.PushSlice([]int{1}))
+	assert.Equal(t, 0, empty.PopInto(dst))
+}
+
+func TestCircularQueuePushSliceOverwrite(t *testing.T) {
+	queue := NewOverwritingCircularQueue[int](4)
+
+	assert.Equal(t, 3, queue.PushSlice([]int{1, 2, 3}))
+	assert.Equal(t, 3, queue.PushSlice([]int{4, 5, 6}))
+	assert.Equal(t, []int{3, 4, 5, 6}, queue.ToSlice())
+	assert.Equal(t, 2, queue.Overwrites())
+
+	assert.Equal(t, 6, queue.PushSlice([]int{7, 8, 9, 10, 11, 12}))
+	assert.Equal(t, []int{9, 10, 11, 12}, queue.ToSlice())
+	assert.Equal(t, 8, queue.Overwrites())
+
+	dst := make([]int, 3)
+	assert.Equal(t, 3, queue.PopInto(dst))
+	assert.Equal(t, []int{9, 10, 11}, dst)
+	assert.Equal(t, 2, queue.PushSlice([]int{13, 14}))
+	assert.Equal(t, []int{12, 13, 14}, queue.ToSlice())
+	assert.Equal(t, 8, queue.Overwrites())
+}
+
+func BenchmarkCircularQueueBatch(b *testing.B) {
+	const size = 1024
+	batch := make([]int, 256)
+	dst := make([]int, 256)
+
+	b.Run("single", func(b *testing.B) {
+		queue := NewCircularQueue[int](size)
+		for i := 0; i < b.N; i++ {
+			for _, value := range batch {
+				queue.Push(value)
+			}
+			for range dst {
+				queue.Pop()
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		queue := NewCircularQueue[int](size)
+		for i := 0; i < b.N; i++ {
+			queue.PushSlice(batch)
+			queue.PopInto(dst)
+		}
+	})
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	ErrQueueClosed = errors.New("queue is closed")
+	ErrQueueFull   = errors.New("queue is full")
+	ErrQueueEmpty  = errors.New("queue is empty")
+)
+
+// BlockingQueue is a goroutine-safe bounded queue over CircularQueue.
+// Waiters park on a channel that is closed and replaced on every state
+// change, which lets them select on a context as well.
+type BlockingQueue[T any] struct {
+	mu      sync.Mutex
+	queue   CircularQueue[T]
+	closed  bool
+	changed chan struct{}
+}
+
+func NewBlockingQueue[T any](size int) *BlockingQueue[T] {
+	return &BlockingQueue[T]{
+		queue:   NewCircularQueue[T](size),
+		changed: make(chan struct{}),
+	}
+}
+
+// notify wakes all waiters; callers hold q.mu.
+func (q *BlockingQueue[T]) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func (q *BlockingQueue[T]) TryPush(value T) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.tryPush(value)
+}
+
+func (q *BlockingQueue[T]) tryPush(value T) error {
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if !q.queue.Push(value) {
+		return ErrQueueFull
+	}
+	q.notify()
+	return nil
+}
+
+// TryPop keeps draining a closed queue and reports ErrQueueClosed only
+// once it is empty.
+func (q *BlockingQueue[T]) TryPop() (T, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.tryPop()
+}
+
+func (q *BlockingQueue[T]) tryPop() (T, error) {
+	value, ok := q.queue.Pop()
+	if ok {
+		q.notify()
+		return value, nil
+	}
+	if q.closed {
+		return value, ErrQueueClosed
+	}
+	return value, ErrQueueEmpty
+}
+
+// Push waits for free space until ctx is done or the queue is closed.
+func (q *BlockingQueue[T]) Push(ctx context.Context, value T) error {
+	for {
+		q.mu.Lock()
+		err := q.tryPush(value)
+		changed := q.changed
+		q.mu.Unlock()
+		if err != ErrQueueFull {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Pop waits for an element until ctx is done or a closed queue is drained.
+func (q *BlockingQueue[T]) Pop(ctx context.Context) (T, error) {
+	for {
+		q.mu.Lock()
+		value, err := q.tryPop()
+		changed := q.changed
+		q.mu.Unlock()
+		if err != ErrQueueEmpty {
+			return value, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return value, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Close rejects further pushes and wakes all waiters. Elements already
+// queued can still be popped.
+func (q *BlockingQueue[T]) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	q.closed = true
+	q.notify()
+	return nil
+}
+
+func (q *BlockingQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queue.sz
+}
+
+func TestBlockingQueueTry(t *testing.T) {
+	queue := NewBlockingQueue[int](2)
+
+	_, err := queue.TryPop()
+	assert.Equal(t, ErrQueueEmpty, err)
+
+	assert.NoError(t, queue.TryPush(1))
+	assert.NoError(t, queue.TryPush(2))
+	assert.Equal(t, ErrQueueFull, queue.TryPush(3))
+	assert.Equal(t, 2, queue.Len())
+
+	value, err := queue.TryPop()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+
+	assert.NoError(t, queue.Close())
+	assert.Equal(t, ErrQueueClosed, queue.Close())
+	assert.Equal(t, ErrQueueClosed, queue.TryPush(4))
+
+	value, err = queue.TryPop()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, value)
+	_, err = queue.TryPop()
+	assert.Equal(t, ErrQueueClosed, err)
+}
+
+func TestBlockingQueueContext(t *testing.T) {
+	queue := NewBlockingQueue[int](1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := queue.Pop(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	assert.NoError(t, queue.Push(context.Background(), 1))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- queue.Push(ctx, 2)
+	}()
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Equal(t, 1, queue.Len())
+}
+
+func TestBlockingQueueWakeUp(t *testing.T) {
+	queue := NewBlockingQueue[int](1)
+	ctx := context.Background()
+
+	popped := make(chan int)
+	go func() {
+		value, err := queue.Pop(ctx)
+		assert.NoError(t, err)
+		popped <- value
+	}()
+	assert.NoError(t, queue.Push(ctx, 7))
+	assert.Equal(t, 7, <-popped)
+
+	assert.NoError(t, queue.Push(ctx, 8))
+	pushed := make(chan error)
+	go func() {
+		pushed <- queue.Push(ctx, 9)
+	}()
+	value, err := queue.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, value)
+	assert.NoError(t, <-pushed)
+
+	// Close wakes blocked producers, consumers drain what is left
+	go func() {
+		pushed <- queue.Push(ctx, 10)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, queue.Close())
+	assert.Equal(t, ErrQueueClosed, <-pushed)
+
+	value, err = queue.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, value)
+
+	errs := make(chan error)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := queue.Pop(ctx)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, ErrQueueClosed, <-errs)
+	}
+}
+
+func TestBlockingQueueConcurrent(t *testing.T) {
+	const producers = 4
+	const consumers = 4
+	const perProducer = 500
+
+	queue := NewBlockingQueue[int](8)
+	ctx := context.Background()
+
+	var producersWg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		producersWg.Add(1)
+		go func(p int) {
+			defer producersWg.Done()
+			for i := 0; i < perProducer; i++ {
+				assert.NoError(t, queue.Push(ctx, p*perProducer+i))
+			}
+		}(p)
+	}
+
+	results := make(chan []int, consumers)
+	for c := 0; c < consumers; c++ {
+		go func() {
+			var received []int
+			for {
+				value, err := queue.Pop(ctx)
+				if err == ErrQueueClosed {
+					results <- received
+					return
+				}
+				assert.NoError(t, err)
+				received = append(received, value)
+			}
+		}()
+	}
+
+	producersWg.Wait()
+	assert.NoError(t, queue.Close())
+
+	var all []int
+	for c := 0; c < consumers; c++ {
+		all = append(all, <-results...)
+	}
+	sort.Ints(all)
+	assert.Equal(t, producers*perProducer, len(all))
+	for i, value := range all {
+		assert.Equal(t, i, value)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	ErrRingFull      = errors.New("ring buffer is full")
+	ErrNegativeCount = errors.New("negative count")
+)
+
+// ByteRing is a fixed-size byte ring with CircularQueue index arithmetic:
+// sz bytes starting at idx. Data moves with at most two copy calls, one per
+// contiguous segment. In blocking mode Read waits for data and Write for
+// space until Close; one reader and one writer goroutine may use it at the
+// same time.
+type ByteRing struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      []byte
+	sz       int
+	idx      int
+	blocking bool
+	closed   bool
+}
+
+func NewByteRing(size int) *ByteRing {
+	r := &ByteRing{buf: make([]byte, size)}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+func NewBlockingByteRing(size int) *ByteRing {
+	r := NewByteRing(size)
+	r.blocking = true
+	return r
+}
+
+// readable returns the first contiguous segment of buffered data.
+func (r *ByteRing) readable() []byte {
+	end := r.idx + r.sz
+	if end > len(r.buf) {
+		end = len(r.buf)
+	}
+	return r.buf[r.idx:end]
+}
+
+// writable returns the first contiguous segment of free space.
+func (r *ByteRing) writable() []byte {
+	if r.sz == len(r.buf) {
+		return nil
+	}
+	tail := (r.idx + r.sz) % len(r.buf)
+	if tail < r.idx {
+		return r.buf[tail:r.idx]
+	}
+	return r.buf[tail:]
+}
+
+func (r *ByteRing) consume(n int) {
+	if n > 0 {
+		r.idx = (r.idx + n) % len(r.buf)
+		r.sz -= n
+		r.cond.Broadcast()
+	}
+}
+
+func (r *ByteRing) commit(n int) {
+	if n > 0 {
+		r.sz += n
+		r.cond.Broadcast()
+	}
+}
+
+// copyOut copies buffered bytes into p without consuming them.
+func (r *ByteRing) copyOut(p []byte) int {
+	n := copy(p, r.readable())
+	if n < len(p) && n < r.sz {
+		n += copy(p[n:], r.buf[:r.sz-n])
+	}
+	return n
+}
+
+// Write stores all of p, or as much as fits and ErrRingFull when not
+// blocking.
+func (r *ByteRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := 0
+	for len(p) > 0 {
+		if r.closed {
+			return total, io.ErrClosedPipe
+		}
+		segment := r.writable()
+		if segment == nil {
+			if !r.blocking {
+				return total, ErrRingFull
+			}
+			r.cond.Wait()
+			continue
+		}
+		n := copy(segment, p)
+		r.commit(n)
+		p = p[n:]
+		total += n
+	}
+	return total, nil
+}
+
+// Read returns io.EOF on an empty ring, like bytes.Buffer; in blocking
+// mode only once the ring is closed and drained.
+func (r *ByteRing) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.sz == 0 {
+		if r.closed || !r.blocking {
+			return 0, io.EOF
+		}
+		r.cond.Wait()
+	}
+	n := r.copyOut(p)
+	r.consume(n)
+	return n, nil
+}
+
+// Peek returns a copy of up to n buffered bytes without consuming them and
+// io.EOF when fewer are available. It never blocks.
+func (r *ByteRing) Peek(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, ErrNegativeCount
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	if n > r.sz {
+		n = r.sz
+		err = io.EOF
+	}
+	p := make([]byte, n)
+	r.copyOut(p)
+	return p, err
+}
+
+// Discard drops up to n buffered bytes and returns io.EOF when fewer were
+// available. It never blocks.
+func (r *ByteRing) Discard(n int) (int, error) {
+	if n < 0 {
+		return 0, ErrNegativeCount
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	if n > r.sz {
+		n = r.sz
+		err = io.EOF
+	}
+	r.consume(n)
+	return n, err
+}
+
+// ReadFrom reads from src straight into the free segments until src is
+// exhausted. Without blocking it stops with ErrRingFull.
+func (r *ByteRing) ReadFrom(src io.Reader) (int64, error) {
+	var total int64
+	for {
+		r.mu.Lock()
+		segment := r.writable()
+		for segment == nil && r.blocking && !r.closed {
+			r.cond.Wait()
+			segment = r.writable()
+		}
+		closed := r.closed
+		r.mu.Unlock()
+		if closed {
+			return total, io.ErrClosedPipe
+		}
+		if segment == nil {
+			return total, ErrRingFull
+		}
+
+		// the reader never touches free space, so no lock is held here
+		n, err := src.Read(segment)
+		r.mu.Lock()
+		r.commit(n)
+		r.mu.Unlock()
+		total += int64(n)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// WriteTo writes buffered segments to dst until the ring is empty, or in
+// blocking mode until it is closed and drained.
+func (r *ByteRing) WriteTo(dst io.Writer) (int64, error) {
+	var total int64
+	for {
+		r.mu.Lock()
+		for r.sz == 0 && r.blocking && !r.closed {
+			r.cond.Wait()
+		}
+		segment := r.readable()
+		r.mu.Unlock()
+		if len(segment) == 0 {
+			return total, nil
+		}
+
+		n, err := dst.Write(segment)
+		r.mu.Lock()
+		r.consume(n)
+		r.mu.Unlock()
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+		if n < len(segment) {
+			return total, io.ErrShortWrite
+		}
+	}
+}
+
+// Close makes further writes fail and wakes blocked readers and writers.
+func (r *ByteRing) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.cond.Broadcast()
+	return nil
+}
+
+func (r *ByteRing) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sz
+}
+
+func (r *ByteRing) Cap() int {
+	return len(r.buf)
+}
+
+var (
+	_ io.ReadWriteCloser = (*ByteRing)(nil)
+	_ io.ReaderFrom      = (*ByteRing)(nil)
+	_ io.WriterTo        = (*ByteRing)(nil)
+)
+
+func TestByteRing(t *testing.T) {
+	ring := NewByteRing(8)
+
+	p := make([]byte, 4)
+	n, err := ring.Read(p)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+
+	n, err = ring.Write([]byte("abcdef"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+
+	n, err = ring.Read(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcd", string(p[:n]))
+
+	// "ef" stays in place, "ghijkl" wraps around the end
+	n, err = ring.Write([]byte("ghijklmn"))
+	assert.Equal(t, ErrRingFull, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, 8, ring.Len())
+	assert.Equal(t, "ijklefgh", string(ring.buf))
+
+	peeked, err := ring.Peek(3)
+	assert.NoError(t, err)
+	assert.Equal(t, "efg", string(peeked))
+	peeked, err = ring.Peek(10)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "efghijkl", string(peeked))
+
+	discarded, err := ring.Discard(3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, discarded)
+
+	all := make([]byte, 16)
+	n, err = ring.Read(all)
+	assert.NoError(t, err)
+	assert.Equal(t, "hijkl", string(all[:n]))
+
+	discarded, err = ring.Discard(1)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, discarded)
+
+	ring.Write([]byte("xyz"))
+	peeked, err = ring.Peek(-1)
+	assert.Equal(t, ErrNegativeCount, err)
+	assert.Nil(t, peeked)
+	discarded, err = ring.Discard(-1)
+	assert.Equal(t, ErrNegativeCount, err)
+	assert.Equal(t, 0, discarded)
+	assert.Equal(t, 3, ring.Len())
+
+	assert.NoError(t, ring.Close())
+	_, err = ring.Write([]byte("x"))
+	assert.Equal(t, io.ErrClosedPipe, err)
+}
+
+func TestByteRingReadFromWriteTo(t *testing.T) {
+	ring := NewByteRing(8)
+	ring.Write([]byte("12345"))
+	ring.Read(make([]byte, 5))
+
+	read, err := ring.ReadFrom(strings.NewReader("abcdefg"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), read)
+
+	read, err = ring.ReadFrom(strings.NewReader("overflow"))
+	assert.Equal(t, ErrRingFull, err)
+	assert.Equal(t, int64(1), read)
+
+	var out bytes.Buffer
+	written, err := ring.WriteTo(&out)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), written)
+	assert.Equal(t, "abcdefgo", out.String())
+	assert.Equal(t, 0, ring.Len())
+
+	read, err = ring.ReadFrom(strings.NewReader("abcdefghijkl"))
+	assert.Equal(t, ErrRingFull, err)
+	assert.Equal(t, int64(8), read)
+}
+
+func TestBlockingByteRing(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	ring := NewBlockingByteRing(100)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// alternate plain writes with ReadFrom
+		half := len(payload) / 2
+		n, err := ring.Write(payload[:half])
+		assert.NoError(t, err)
+		assert.Equal(t, half, n)
+		read, err := ring.ReadFrom(bytes.NewReader(payload[half:]))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(payload)-half), read)
+		ring.Close()
+	}()
+
+	hash := sha256.New()
+	head := make([]byte, 1000)
+	_, err := io.ReadFull(ring, head)
+	assert.NoError(t, err)
+	hash.Write(head)
+	_, err = ring.WriteTo(hash)
+	assert.NoError(t, err)
+	wg.Wait()
+
+	assert.Equal(t, sha256.Sum256(payload), [sha256.Size]byte(hash.Sum(nil)))
+
+	n, err := ring.Read(head)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestBlockingByteRingClose(t *testing.T) {
+	ring := NewBlockingByteRing(4)
+	ring.Write([]byte("full"))
+
+	done := make(chan error)
+	go func() {
+		_, err := ring.Write([]byte("more"))
+		done <- err
+	}()
+	go func() {
+		_, err := ring.ReadFrom(strings.NewReader("more"))
+		done <- err
+	}()
+	ring.Close()
+	assert.Equal(t, io.ErrClosedPipe, <-done)
+	assert.Equal(t, io.ErrClosedPipe, <-done)
+
+	// data written before Close can still be read
+	data, err := io.ReadAll(ring)
+	assert.NoError(t, err)
+	assert.Equal(t, "full", string(data))
+}
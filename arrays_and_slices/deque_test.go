@@ -0,0 +1,215 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const minDequeCapacity = 4
+
+// Deque is a growable double-ended queue with the CircularQueue layout:
+// sz elements starting at idx, wrapping around the end of values. The ring
+// doubles when full and halves when under a quarter full, re-linearizing
+// the elements each time.
+type Deque[T any] struct {
+	values []T
+	sz int
+	idx int
+}
+
+func NewDeque[T any](size int) Deque[T] {
+	return Deque[T]{values: make([]T, size)}
+}
+
+func (d *Deque[T]) resize(size int) {
+	values := make([]T, size)
+	n := copy(values[:d.sz], d.values[d.idx:])
+	copy(values[n:d.sz], d.values[:d.sz-n])
+	d.values = values
+	d.idx = 0
+}
+
+func (d *Deque[T]) grow() {
+	if d.sz < len(d.values) {
+		return
+	}
+	size := 2 * len(d.values)
+	if size < minDequeCapacity {
+		size = minDequeCapacity
+	}
+	d.resize(size)
+}
+
+func (d *Deque[T]) shrink() {
+	if len(d.values) > minDequeCapacity && d.sz < len(d.values)/4 {
+		d.resize(len(d.values) / 2)
+	}
+}
+
+func (d *Deque[T]) PushBack(value T) {
+	d.grow()
+	d.values[(d.idx + d.sz) % len(d.values)] = value
+	d.sz++
+}
+
+func (d *Deque[T]) PushFront(value T) {
+	d.grow()
+	d.idx = (d.idx - 1 + len(d.values)) % len(d.values)
+	d.values[d.idx] = value
+	d.sz++
+}
+
+func (d *Deque[T]) PopFront() (T, bool) {
+	var zero T
+	if d.sz == 0 {
+		return zero, false
+	}
+	value := d.values[d.idx]
+	d.values[d.idx] = zero
+	d.idx = (d.idx + 1) % len(d.values)
+	d.sz--
+	d.shrink()
+	return value, true
+}
+
+func (d *Deque[T]) PopBack() (T, bool) {
+	var zero T
+	if d.sz == 0 {
+		return zero, false
+	}
+	last := (d.idx + d.sz - 1) % len(d.values)
+	value := d.values[last]
+	d.values[last] = zero
+	d.sz--
+	d.shrink()
+	return value, true
+}
+
+// At returns the i-th element counting from the front.
+func (d *Deque[T]) At(i int) (T, bool) {
+	if i < 0 || i >= d.sz {
+		var zero T
+		return zero, false
+	}
+	return d.values[(d.idx + i) % len(d.values)], true
+}
+
+func (d *Deque[T]) Front() (T, bool) {
+	return d.At(0)
+}
+
+func (d *Deque[T]) Back() (T, bool) {
+	return d.At(d.sz - 1)
+}
+
+func (d *Deque[T]) Len() int {
+	return d.sz
+}
+
+func (d *Deque[T]) Empty() bool {
+	return d.sz == 0
+}
+
+func dequeContents[T any](d *Deque[T]) []T {
+	var values []T
+	for i := 0; i < d.Len(); i++ {
+		value, _ := d.At(i)
+		values = append(values, value)
+	}
+	return values
+}
+
+func TestDeque(t *testing.T) {
+	var deque Deque[int]
+
+	assert.True(t, deque.Empty())
+	_, ok := deque.PopFront()
+	assert.False(t, ok)
+	_, ok = deque.PopBack()
+	assert.False(t, ok)
+	_, ok = deque.Front()
+	assert.False(t, ok)
+	_, ok = deque.Back()
+	assert.False(t, ok)
+
+	deque.PushBack(2)
+	deque.PushBack(3)
+	deque.PushFront(1)
+	deque.PushFront(0)
+	assert.Equal(t, minDequeCapacity, len(deque.values))
+	assert.Equal(t, []int{0, 1, 2, 3}, dequeContents(&deque))
+
+	// the ring wraps: front lives at the end of values
+	assert.Equal(t, 2, deque.idx)
+	assert.Equal(t, []int{2, 3, 0, 1}, deque.values)
+
+	deque.PushBack(4)
+	assert.Equal(t, 8, len(deque.values))
+	assert.Equal(t, 0, deque.idx)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 0, 0, 0}, deque.values)
+
+	front, _ := deque.Front()
+	assert.Equal(t, 0, front)
+	back, _ := deque.Back()
+	assert.Equal(t, 4, back)
+	value, ok := deque.At(2)
+	assert.True(t, ok)
+	assert.Equal(t, 2, value)
+	_, ok = deque.At(5)
+	assert.False(t, ok)
+	_, ok = deque.At(-1)
+	assert.False(t, ok)
+
+	value, _ = deque.PopBack()
+	assert.Equal(t, 4, value)
+	value, _ = deque.PopFront()
+	assert.Equal(t, 0, value)
+	value, _ = deque.PopFront()
+	assert.Equal(t, 1, value)
+	assert.Equal(t, 8, len(deque.values))
+
+	// one of eight left, halve and re-linearize
+	value, _ = deque.PopBack()
+	assert.Equal(t, 3, value)
+	assert.Equal(t, 4, len(deque.values))
+	assert.Equal(t, []int{2}, dequeContents(&deque))
+
+	value, _ = deque.PopFront()
+	assert.Equal(t, 2, value)
+	assert.True(t, deque.Empty())
+	assert.Equal(t, minDequeCapacity, len(deque.values))
+}
+
+func TestDequeGrowShrink(t *testing.T) {
+	deque := NewDeque[int](2)
+	var model []int
+
+	for i := 0; i < 1000; i++ {
+		if i%3 == 0 {
+			deque.PushFront(i)
+			model = append([]int{i}, model...)
+		} else {
+			deque.PushBack(i)
+			model = append(model, i)
+		}
+	}
+	assert.Equal(t, model, dequeContents(&deque))
+	assert.Equal(t, 1024, len(deque.values))
+
+	for len(model) > 1 {
+		if len(model)%2 == 0 {
+			value, ok := deque.PopFront()
+			assert.True(t, ok)
+			assert.Equal(t, model[0], value)
+			model = model[1:]
+		} else {
+			value, ok := deque.PopBack()
+			assert.True(t, ok)
+			assert.Equal(t, model[len(model)-1], value)
+			model = model[:len(model)-1]
+		}
+	}
+	assert.Equal(t, model, dequeContents(&deque))
+	assert.Equal(t, minDequeCapacity, len(deque.values))
+}
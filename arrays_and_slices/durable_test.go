@@ -0,0 +1,625 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	recordPush byte = iota + 1
+	recordPop
+	recordReset // a snapshot follows, drop everything replayed so far
+)
+
+const (
+	recordHeaderSize = 9 // payload length, crc32 of type and payload, type
+	segmentExt       = ".seg"
+	tmpExt           = ".tmp"
+)
+
+var (
+	ErrCorruptLog  = errors.New("corrupt queue log")
+	ErrLogCapacity = errors.New("queue log holds more values than the queue size")
+)
+
+type durableConfig struct {
+	segmentSize  int64
+	compactEvery int
+}
+
+type DurableOption func(*durableConfig)
+
+// WithSegmentSize starts a new segment file once the active one reaches
+// size bytes.
+func WithSegmentSize(size int64) DurableOption {
+	return func(config *durableConfig) {
+		config.segmentSize = size
+	}
+}
+
+// WithCompactEvery rewrites the log as a snapshot of the live values once
+// it holds that many records more than there are live values.
+func WithCompactEvery(records int) DurableOption {
+	return func(config *durableConfig) {
+		config.compactEvery = records
+	}
+}
+
+// DurableQueue mirrors a CircularQueue in a write-ahead log of segment
+// files. Every Push and Pop appends a checksummed record and syncs it
+// before touching memory; on open the log is replayed and a torn tail of
+// the last segment is truncated. Values are stored as JSON.
+//
+// Push and Pop keep the CircularQueue signatures: an I/O failure makes them
+// return false from then on, and Err reports it.
+type DurableQueue[T any] struct {
+	dir      string
+	config   durableConfig
+	mem      CircularQueue[T]
+	file     *os.File
+	segments []int // ids of segment files on disk, the last one is active
+	written  int64 // bytes in the active segment
+	logged   int   // records since the last reset, including the snapshot
+	err      error
+}
+
+func segmentPath(dir string, id int) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+func OpenDurableQueue[T any](dir string, size int, options ...DurableOption) (*DurableQueue[T], error) {
+	q := &DurableQueue[T]{
+		dir:    dir,
+		config: durableConfig{segmentSize: 1 << 20, compactEvery: 1024},
+		mem:    NewCircularQueue[T](size),
+	}
+	for _, option := range options {
+		option(&q.config)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, tmpExt):
+			// unfinished snapshot, the old segments are still complete
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return nil, err
+			}
+		case strings.HasSuffix(name, segmentExt):
+			var id int
+			if _, err := fmt.Sscanf(name, "%d"+segmentExt, &id); err != nil {
+				return nil, fmt.Errorf("%w: unexpected file %s", ErrCorruptLog, name)
+			}
+			q.segments = append(q.segments, id)
+		}
+	}
+	sort.Ints(q.segments)
+
+	for i, id := range q.segments {
+		if err := q.replay(id, i == len(q.segments)-1); err != nil {
+			return nil, err
+		}
+	}
+	if len(q.segments) == 0 {
+		q.segments = []int{1}
+	}
+	if err := q.openActive(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// replay applies one segment to memory. Only the last segment may end with
+// a torn or corrupt record; it is cut off there.
+func (q *DurableQueue[T]) replay(id int, last bool) error {
+	path := segmentPath(q.dir, id)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	offset := 0
+	for offset < len(data) {
+		kind, payload, n := decodeRecord(data[offset:])
+		if n == 0 {
+			if !last {
+				return fmt.Errorf("%w: segment %d at offset %d", ErrCorruptLog, id, offset)
+			}
+			return os.Truncate(path, int64(offset))
+		}
+		if err := q.apply(kind, payload); err != nil {
+			return err
+		}
+		offset += n
+		q.logged++
+	}
+	return nil
+}
+
+func (q *DurableQueue[T]) apply(kind byte, payload []byte) error {
+	switch kind {
+	case recordPush:
+		var value T
+		if err := json.Unmarshal(payload, &value); err != nil {
+			return fmt.Errorf("%w: %v", ErrCorruptLog, err)
+		}
+		if !q.mem.Push(value) {
+			return ErrLogCapacity
+		}
+	case recordPop:
+		if _, ok := q.mem.Pop(); !ok {
+			return fmt.Errorf("%w: pop from an empty queue", ErrCorruptLog)
+		}
+	case recordReset:
+		q.mem.discard(q.mem.sz)
+		q.logged = 0
+	default:
+		return fmt.Errorf("%w: unknown record type %d", ErrCorruptLog, kind)
+	}
+	return nil
+}
+
+func encodeRecord(dst []byte, kind byte, payload []byte) []byte {
+	var header [recordHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	crc := crc32.ChecksumIEEE([]byte{kind})
+	crc = crc32.Update(crc, crc32.IEEETable, payload)
+	binary.LittleEndian.PutUint32(header[4:8], crc)
+	header[8] = kind
+	return append(append(dst, header[:]...), payload...)
+}
+
+// decodeRecord returns the size of the record at the start of data, or 0
+// if it is truncated or fails its checksum.
+func decodeRecord(data []byte) (byte, []byte, int) {
+	if len(data) < recordHeaderSize {
+		return 0, nil, 0
+	}
+	length := binary.LittleEndian.Uint32(data[0:4])
+	if uint64(length) > uint64(len(data)-recordHeaderSize) {
+		return 0, nil, 0
+	}
+	kind := data[8]
+	payload := data[recordHeaderSize : recordHeaderSize+int(length)]
+	crc := crc32.ChecksumIEEE([]byte{kind})
+	crc = crc32.Update(crc, crc32.IEEETable, payload)
+	if crc != binary.LittleEndian.Uint32(data[4:8]) {
+		return 0, nil, 0
+	}
+	return kind, payload, recordHeaderSize + int(length)
+}
+
+func (q *DurableQueue[T]) openActive() error {
+	file, err := os.OpenFile(segmentPath(q.dir, q.segments[len(q.segments)-1]), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	q.file = file
+	q.written = info.Size()
+	if q.written == 0 {
+		// the segment may have just been created, persist its entry
+		syncDir(q.dir)
+	}
+	return nil
+}
+
+func (q *DurableQueue[T]) append(kind byte, payload []byte) bool {
+	if q.err != nil {
+		return false
+	}
+	record := encodeRecord(nil, kind, payload)
+	if _, err := q.file.Write(record); err != nil {
+		q.err = err
+		return false
+	}
+	if err := q.file.Sync(); err != nil {
+		q.err = err
+		return false
+	}
+	q.written += int64(len(record))
+	q.logged++
+	return true
+}
+
+// maybeRotate runs after memory reflects the last record, so a snapshot
+// taken by compaction is up to date.
+func (q *DurableQueue[T]) maybeRotate() {
+	if q.logged-q.mem.sz >= q.config.compactEvery {
+		q.err = q.compact()
+	} else if q.written >= q.config.segmentSize {
+		q.err = q.rotate()
+	}
+}
+
+// closeActive closes the active segment once; Close skips it afterwards.
+func (q *DurableQueue[T]) closeActive() error {
+	file := q.file
+	q.file = nil
+	return file.Close()
+}
+
+func (q *DurableQueue[T]) rotate() error {
+	if err := q.closeActive(); err != nil {
+		return err
+	}
+	q.segments = append(q.segments, q.segments[len(q.segments)-1]+1)
+	return q.openActive()
+}
+
+// removeSegment is os.Remove, replaced in tests to interrupt compaction.
+var removeSegment = os.Remove
+
+// compact writes the live values into a temporary file, renames it into
+// the next segment and then deletes the older segments. A crash before the
+// rename leaves a .tmp file that is ignored. Old segments are deleted
+// newest first, so after a crash during deletion the survivors are still a
+// prefix of the log that replays cleanly up to the snapshot's reset record.
+func (q *DurableQueue[T]) compact() error {
+	id := q.segments[len(q.segments)-1] + 1
+	path := segmentPath(q.dir, id)
+
+	snapshot := encodeRecord(nil, recordReset, nil)
+	var err error
+	q.mem.Range(func(_ int, value T) bool {
+		var payload []byte
+		if payload, err = json.Marshal(value); err != nil {
+			return false
+		}
+		snapshot = encodeRecord(snapshot, recordPush, payload)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(path+tmpExt, snapshot); err != nil {
+		return err
+	}
+	if err := os.Rename(path+tmpExt, path); err != nil {
+		return err
+	}
+	syncDir(q.dir)
+
+	if err := q.closeActive(); err != nil {
+		return err
+	}
+	for i := len(q.segments) - 1; i >= 0; i-- {
+		if err := removeSegment(segmentPath(q.dir, q.segments[i])); err != nil {
+			return err
+		}
+	}
+	q.segments = []int{id}
+	q.logged = q.mem.sz + 1
+	return q.openActive()
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir persists a rename; not every platform supports it, hence no error.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+func (q *DurableQueue[T]) Push(value T) bool {
+	if q.mem.Full() {
+		return false
+	}
+	payload, err := json.Marshal(value)
+	if err != nil {
+		q.err = err
+		return false
+	}
+	if !q.append(recordPush, payload) {
+		return false
+	}
+	q.mem.Push(value)
+	q.maybeRotate()
+	return true
+}
+
+func (q *DurableQueue[T]) Pop() (T, bool) {
+	if q.mem.Empty() || !q.append(recordPop, nil) {
+		var zero T
+		return zero, false
+	}
+	value, _ := q.mem.Pop()
+	q.maybeRotate()
+	return value, true
+}
+
+func (q *DurableQueue[T]) Front() (T, bool) {
+	return q.mem.Front()
+}
+
+func (q *DurableQueue[T]) Back() (T, bool) {
+	return q.mem.Back()
+}
+
+func (q *DurableQueue[T]) Empty() bool {
+	return q.mem.Empty()
+}
+
+func (q *DurableQueue[T]) Full() bool {
+	return q.mem.Full()
+}
+
+func (q *DurableQueue[T]) Len() int {
+	return q.mem.sz
+}
+
+// Err returns the first I/O error; once set, Push and Pop fail.
+func (q *DurableQueue[T]) Err() error {
+	return q.err
+}
+
+// Close reports the first I/O error, if any, before an error closing the
+// active segment.
+func (q *DurableQueue[T]) Close() error {
+	var err error
+	if q.file != nil {
+		err = q.closeActive()
+	}
+	if q.err != nil {
+		return q.err
+	}
+	return err
+}
+
+type job struct {
+	ID   int
+	Name string
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	assert.NoError(t, err)
+	for i := range names {
+		names[i] = filepath.Base(names[i])
+	}
+	return names
+}
+
+func TestDurableQueue(t *testing.T) {
+	dir := t.TempDir()
+
+	queue, err := OpenDurableQueue[job](dir, 3)
+	assert.NoError(t, err)
+	_, ok := queue.Front()
+	assert.False(t, ok)
+	_, ok = queue.Pop()
+	assert.False(t, ok)
+
+	assert.True(t, queue.Push(job{1, "build"}))
+	assert.True(t, queue.Push(job{2, "test"}))
+	assert.True(t, queue.Push(job{3, "deploy"}))
+	assert.False(t, queue.Push(job{4, "overflow"}))
+	assert.True(t, queue.Full())
+
+	popped, ok := queue.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, job{1, "build"}, popped)
+	assert.NoError(t, queue.Close())
+
+	queue, err = OpenDurableQueue[job](dir, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, queue.Len())
+	front, _ := queue.Front()
+	assert.Equal(t, job{2, "test"}, front)
+	back, _ := queue.Back()
+	assert.Equal(t, job{3, "deploy"}, back)
+
+	assert.True(t, queue.Push(job{4, "notify"}))
+	assert.NoError(t, queue.Close())
+
+	_, err = OpenDurableQueue[job](dir, 2)
+	assert.Equal(t, ErrLogCapacity, err)
+}
+
+func TestDurableQueueTornWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	queue, err := OpenDurableQueue[int](dir, 10)
+	assert.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		assert.True(t, queue.Push(i))
+	}
+	assert.NoError(t, queue.Close())
+
+	path := segmentPath(dir, 1)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	intact := info.Size()
+
+	// a crash in the middle of the fourth record
+	record := encodeRecord(nil, recordPush, []byte("4"))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	assert.NoError(t, err)
+	file.Write(record[:len(record)-1])
+	file.Close()
+
+	queue, err = OpenDurableQueue[int](dir, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, queue.Len())
+	info, err = os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, intact, info.Size())
+
+	assert.True(t, queue.Push(5))
+	assert.NoError(t, queue.Close())
+
+	// a flipped bit in the last record fails its checksum
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	data[len(data)-1] ^= 0x01
+	assert.NoError(t, os.WriteFile(path, data, 0o644))
+
+	queue, err = OpenDurableQueue[int](dir, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, queue.Len())
+	back, _ := queue.Back()
+	assert.Equal(t, 3, back)
+	assert.NoError(t, queue.Close())
+}
+
+func TestDurableQueueSegments(t *testing.T) {
+	dir := t.TempDir()
+
+	queue, err := OpenDurableQueue[int](dir, 100, WithSegmentSize(64), WithCompactEvery(1000))
+	assert.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		assert.True(t, queue.Push(i))
+	}
+	for i := 0; i < 5; i++ {
+		queue.Pop()
+	}
+	assert.NoError(t, queue.Close())
+	assert.Greater(t, len(segmentFiles(t, dir)), 1)
+
+	queue, err = OpenDurableQueue[int](dir, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 15, queue.Len())
+	front, _ := queue.Front()
+	assert.Equal(t, 5, front)
+	assert.NoError(t, queue.Close())
+
+	// damage in a sealed segment is not a torn write
+	first := segmentPath(dir, 1)
+	data, err := os.ReadFile(first)
+	assert.NoError(t, err)
+	data[recordHeaderSize] ^= 0xff
+	assert.NoError(t, os.WriteFile(first, data, 0o644))
+	_, err = OpenDurableQueue[int](dir, 100)
+	assert.True(t, errors.Is(err, ErrCorruptLog))
+}
+
+func TestDurableQueueCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	queue, err := OpenDurableQueue[int](dir, 4, WithCompactEvery(10))
+	assert.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		if queue.Full() {
+			queue.Pop()
+		}
+		assert.True(t, queue.Push(i))
+	}
+	assert.NoError(t, queue.Err())
+	files := segmentFiles(t, dir)
+	assert.Equal(t, 1, len(files))
+	assert.NoError(t, queue.Close())
+
+	// leftovers of an interrupted compaction
+	active := filepath.Join(dir, files[0])
+	snapshot, err := os.ReadFile(active)
+	assert.NoError(t, err)
+	stale := encodeRecord(nil, recordPush, []byte("100"))
+	assert.NoError(t, os.WriteFile(segmentPath(dir, 0), stale, 0o644))
+	assert.NoError(t, os.WriteFile(active+tmpExt, snapshot[:5], 0o644))
+
+	queue, err = OpenDurableQueue[int](dir, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{46, 47, 48, 49}, queue.mem.ToSlice())
+	assert.NotContains(t, segmentFiles(t, dir), files[0]+tmpExt)
+	assert.NoError(t, queue.Close())
+}
+
+func TestDurableQueueInterruptedCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	queue, err := OpenDurableQueue[int](dir, 100, WithSegmentSize(64), WithCompactEvery(1000))
+	assert.NoError(t, err)
+	for i := 0; i < 12; i++ {
+		assert.True(t, queue.Push(i))
+	}
+	// the later segments pop values pushed in the first one
+	for i := 0; i < 10; i++ {
+		queue.Pop()
+	}
+	segments := len(queue.segments)
+	assert.Greater(t, segments, 2)
+
+	// a crash after the first segment is deleted
+	removed := 0
+	removeSegment = func(path string) error {
+		if removed == 1 {
+			return errors.New("crash")
+		}
+		removed++
+		return os.Remove(path)
+	}
+	defer func() { removeSegment = os.Remove }()
+	queue.config.compactEvery = 1
+	queue.Pop()
+	assert.EqualError(t, queue.Err(), "crash")
+	assert.EqualError(t, queue.Close(), "crash")
+	assert.Equal(t, segments, len(segmentFiles(t, dir)))
+
+	queue, err = OpenDurableQueue[int](dir, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{11}, queue.mem.ToSlice())
+	assert.NoError(t, queue.Close())
+}
+
+func TestDurableQueueCompactionThreshold(t *testing.T) {
+	queue, err := OpenDurableQueue[int](t.TempDir(), 40, WithCompactEvery(10))
+	assert.NoError(t, err)
+
+	// live values alone never trigger a compaction, however many there are
+	for i := 0; i < 20; i++ {
+		assert.True(t, queue.Push(i))
+	}
+	assert.Equal(t, []int{1}, queue.segments)
+
+	for i := 0; i < 5; i++ {
+		queue.Pop()
+	}
+	assert.Equal(t, []int{2}, queue.segments)
+
+	// each pop makes its own record and the popped push dead
+	for i := 0; i < 4; i++ {
+		assert.True(t, queue.Push(i))
+		queue.Pop()
+	}
+	assert.Equal(t, []int{2}, queue.segments)
+	assert.True(t, queue.Push(4))
+	queue.Pop()
+	assert.Equal(t, []int{3}, queue.segments)
+	assert.Equal(t, 15, queue.Len())
+	assert.NoError(t, queue.Close())
+}
@@ -9,22 +9,24 @@ import (
 
 // go test -v homework_test.go
 
-type Integer interface {
-	int | int8 | int16 | int32 | int64
-}
-
-type CircularQueue[T Integer] struct {
+type CircularQueue[T any] struct {
 	values []T
 	sz int
 	idx int
+	overwrite bool // Push on a full queue evicts the front
+	overwrites int
 }
 
-func NewCircularQueue[T Integer](size int) CircularQueue[T] {
+func NewCircularQueue[T any](size int) CircularQueue[T] {
 	return CircularQueue[T]{values: make([]T, size, size)}
 }
 
 func (q *CircularQueue[T]) Push(value T) bool {
 	if q.Full() {
+		if q.overwrite {
+			q.PushOverwrite(value)
+			return true
+		}
 		return false
 	}
 	q.values[(q.idx + q.sz) % cap(q.values)] = value
@@ -32,28 +34,33 @@ func (q *CircularQueue[T]) Push(value T) bool {
 	return true
 }
 
-func (q *CircularQueue[T]) Pop() bool {
+func (q *CircularQueue[T]) Pop() (T, bool) {
+	var zero T
 	if q.Empty() {
-		return false
+		return zero, false
 	}
+	value := q.values[q.idx]
+	q.values[q.idx] = zero // drop reference for GC
 	q.idx = (q.idx + 1) % cap(q.values)
 	q.sz--
-	return true
+	return value, true
 }
 
-func (q *CircularQueue[T]) Front() T {
+func (q *CircularQueue[T]) Front() (T, bool) {
 	if q.Empty() {
-		return -1
+		var zero T
+		return zero, false
 	}
-	return q.values[q.idx]
+	return q.values[q.idx], true
 
 }
 
-func (q *CircularQueue[T]) Back() T {
+func (q *CircularQueue[T]) Back() (T, bool) {
 	if q.Empty() {
-		return -1
+		var zero T
+		return zero, false
 	}
-	return q.values[(q.idx + q.sz - 1) % cap(q.values)]
+	return q.values[(q.idx + q.sz - 1) % cap(q.values)], true
 }
 
 func (q *CircularQueue[T]) Empty() bool {
@@ -71,9 +78,12 @@ func TestCircularQueue(t *testing.T) {
 	assert.True(t, queue.Empty())
 	assert.False(t, queue.Full())
 
-	assert.Equal(t, int32(-1), queue.Front())
-	assert.Equal(t, int32(-1), queue.Back())
-	assert.False(t, queue.Pop())
+	_, ok := queue.Front()
+	assert.False(t, ok)
+	_, ok = queue.Back()
+	assert.False(t, ok)
+	_, ok = queue.Pop()
+	assert.False(t, ok)
 
 	assert.True(t, queue.Push(1))
 	assert.True(t, queue.Push(2))
@@ -85,24 +95,77 @@ func TestCircularQueue(t *testing.T) {
 	assert.False(t, queue.Empty())
 	assert.True(t, queue.Full())
 
-	assert.Equal(t, int32(1), queue.Front())
-	assert.Equal(t, int32(3), queue.Back())
+	front, ok := queue.Front()
+	assert.True(t, ok)
+	assert.Equal(t, int32(1), front)
+	back, ok := queue.Back()
+	assert.True(t, ok)
+	assert.Equal(t, int32(3), back)
 
-	assert.True(t, queue.Pop())
+	value, ok := queue.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, int32(1), value)
 	assert.False(t, queue.Empty())
 	assert.False(t, queue.Full())
 	assert.True(t, queue.Push(4))
 
 	assert.True(t, reflect.DeepEqual([]int32{4, 2, 3}, queue.values))
 
-	assert.Equal(t, int32(2), queue.Front())
-	assert.Equal(t, int32(4), queue.Back())
-
-	assert.True(t, queue.Pop())
-	assert.True(t, queue.Pop())
-	assert.True(t, queue.Pop())
-	assert.False(t, queue.Pop())
+	front, _ = queue.Front()
+	assert.Equal(t, int32(2), front)
+	back, _ = queue.Back()
+	assert.Equal(t, int32(4), back)
+
+	value, ok = queue.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, int32(2), value)
+	value, ok = queue.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, int32(3), value)
+	value, ok = queue.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, int32(4), value)
+	_, ok = queue.Pop()
+	assert.False(t, ok)
 
 	assert.True(t, queue.Empty())
 	assert.False(t, queue.Full())
 }
+
+func TestCircularQueueAnyType(t *testing.T) {
+	type sample struct {
+		name  string
+		value float64
+	}
+
+	queue := NewCircularQueue[*sample](2)
+
+	first := &sample{"cpu", 0.5}
+	assert.True(t, queue.Push(first))
+	assert.True(t, queue.Push(nil))
+	assert.False(t, queue.Push(&sample{"mem", 1}))
+
+	back, ok := queue.Back()
+	assert.True(t, ok)
+	assert.Nil(t, back)
+
+	popped, ok := queue.Pop()
+	assert.True(t, ok)
+	assert.True(t, first == popped)
+	assert.Nil(t, queue.values[0])
+
+	popped, ok = queue.Pop()
+	assert.True(t, ok)
+	assert.Nil(t, popped)
+
+	popped, ok = queue.Pop()
+	assert.False(t, ok)
+	assert.Nil(t, popped)
+
+	// -1 is an ordinary value, not an empty marker
+	numbers := NewCircularQueue[int](1)
+	assert.True(t, numbers.Push(-1))
+	front, ok := numbers.Front()
+	assert.True(t, ok)
+	assert.Equal(t, -1, front)
+}
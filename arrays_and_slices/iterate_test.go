@@ -0,0 +1,155 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (q *CircularQueue[T]) Len() int {
+	return q.sz
+}
+
+// At returns the i-th element counting from the front.
+func (q *CircularQueue[T]) At(i int) (T, bool) {
+	if i < 0 || i >= q.sz {
+		var zero T
+		return zero, false
+	}
+	return q.values[(q.idx + i) % cap(q.values)], true
+}
+
+// CopyTo copies up to len(dst) elements in queue order and returns how many
+// were copied. A wrapped ring takes two copy calls, one per segment.
+func (q *CircularQueue[T]) CopyTo(dst []T) int {
+	count := q.sz
+	if len(dst) < count {
+		count = len(dst)
+	}
+	n := copy(dst[:count], q.values[q.idx:])
+	return n + copy(dst[n:count], q.values[:count-n])
+}
+
+func (q *CircularQueue[T]) ToSlice() []T {
+	values := make([]T, q.sz)
+	q.CopyTo(values)
+	return values
+}
+
+// Range calls fn from front to back until fn returns false.
+func (q *CircularQueue[T]) Range(fn func(i int, value T) bool) {
+	for i := 0; i < q.sz; i++ {
+		if !fn(i, q.values[(q.idx + i) % cap(q.values)]) {
+			return
+		}
+	}
+}
+
+// All and Backward return iterators shaped like iter.Seq2, so they can be
+// ranged over directly with Go 1.23 and newer.
+func (q *CircularQueue[T]) All() func(yield func(int, T) bool) {
+	return q.Range
+}
+
+func (q *CircularQueue[T]) Backward() func(yield func(int, T) bool) {
+	return func(yield func(int, T) bool) {
+		for i := q.sz - 1; i >= 0; i-- {
+			if !yield(i, q.values[(q.idx + i) % cap(q.values)]) {
+				return
+			}
+		}
+	}
+}
+
+func collect[T any](seq func(yield func(int, T) bool)) ([]int, []T) {
+	var indices []int
+	var values []T
+	seq(func(i int, value T) bool {
+		indices = append(indices, i)
+		values = append(values, value)
+		return true
+	})
+	return indices, values
+}
+
+func TestCircularQueueIteration(t *testing.T) {
+	queue := NewCircularQueue[int](4)
+	assert.Equal(t, 0, queue.Len())
+	assert.Equal(t, []int{}, queue.ToSlice())
+	_, values := collect(queue.All())
+	assert.Nil(t, values)
+
+	for i := 1; i <= 4; i++ {
+		queue.Push(i)
+	}
+	queue.Pop()
+	queue.Pop()
+	queue.Push(5)
+
+	// physical layout [5 0 3 4], logical order 3 4 5
+	assert.Equal(t, []int{5, 0, 3, 4}, queue.values)
+	assert.Equal(t, 3, queue.Len())
+	assert.Equal(t, []int{3, 4, 5}, queue.ToSlice())
+
+	value, ok := queue.At(2)
+	assert.True(t, ok)
+	assert.Equal(t, 5, value)
+	_, ok = queue.At(3)
+	assert.False(t, ok)
+	_, ok = queue.At(-1)
+	assert.False(t, ok)
+
+	indices, values := collect(queue.All())
+	assert.Equal(t, []int{0, 1, 2}, indices)
+	assert.Equal(t, []int{3, 4, 5}, values)
+
+	indices, values = collect(queue.Backward())
+	assert.Equal(t, []int{2, 1, 0}, indices)
+	assert.Equal(t, []int{5, 4, 3}, values)
+
+	var visited []int
+	queue.Range(func(_ int, value int) bool {
+		visited = append(visited, value)
+		return value < 4
+	})
+	assert.Equal(t, []int{3, 4}, visited)
+
+	visited = nil
+	queue.Backward()(func(_ int, value int) bool {
+		visited = append(visited, value)
+		return false
+	})
+	assert.Equal(t, []int{5}, visited)
+}
+
+func TestCircularQueueCopyTo(t *testing.T) {
+	queue := NewCircularQueue[int](5)
+	for i := 0; i < 5; i++ {
+		queue.Push(i)
+	}
+	for i := 0; i < 3; i++ {
+		queue.Pop()
+	}
+	queue.Push(5)
+	queue.Push(6)
+
+	short := make([]int, 3)
+	assert.Equal(t, 3, queue.CopyTo(short))
+	assert.Equal(t, []int{3, 4, 5}, short)
+
+	long := []int{-1, -1, -1, -1, -1, -1}
+	assert.Equal(t, 4, queue.CopyTo(long))
+	assert.Equal(t, []int{3, 4, 5, 6, -1, -1}, long)
+
+	one := make([]int, 1)
+	assert.Equal(t, 1, queue.CopyTo(one))
+	assert.Equal(t, []int{3}, one)
+
+	assert.Equal(t, 0, queue.CopyTo(nil))
+
+	// not wrapped, a single segment
+	linear := NewCircularQueue[int](3)
+	linear.Push(7)
+	linear.Push(8)
+	assert.Equal(t, []int{7, 8}, linear.ToSlice())
+}
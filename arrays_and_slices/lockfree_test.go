@@ -0,0 +1,348 @@
+package main
+
+import (
+	"math/bits"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const cacheLineSize = 64
+
+// roundUpPowerOfTwo lets ring indices be masked instead of taken modulo.
+func roundUpPowerOfTwo(size int) int {
+	if size <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(size-1))
+}
+
+// SPSCQueue is a lock-free ring for exactly one producer and one consumer
+// goroutine. Head and tail only grow; the slot is the counter masked by
+// capacity-1.
+type SPSCQueue[T any] struct {
+	values []T
+	mask   uint64
+	_      [cacheLineSize]byte
+	head   atomic.Uint64 // next slot to pop, written by the consumer
+	_      [cacheLineSize - 8]byte
+	tail   atomic.Uint64 // next slot to push, written by the producer
+	_      [cacheLineSize - 8]byte
+}
+
+func NewSPSCQueue[T any](size int) *SPSCQueue[T] {
+	size = roundUpPowerOfTwo(size)
+	return &SPSCQueue[T]{values: make([]T, size), mask: uint64(size - 1)}
+}
+
+func (q *SPSCQueue[T]) Push(value T) bool {
+	tail := q.tail.Load()
+	if tail-q.head.Load() == uint64(len(q.values)) {
+		return false
+	}
+	q.values[tail&q.mask] = value
+	q.tail.Store(tail + 1)
+	return true
+}
+
+func (q *SPSCQueue[T]) Pop() (T, bool) {
+	var zero T
+	head := q.head.Load()
+	if head == q.tail.Load() {
+		return zero, false
+	}
+	value := q.values[head&q.mask]
+	q.values[head&q.mask] = zero
+	q.head.Store(head + 1)
+	return value, true
+}
+
+func (q *SPSCQueue[T]) Cap() int {
+	return len(q.values)
+}
+
+type mpmcSlot[T any] struct {
+	seq   atomic.Uint64
+	value T
+}
+
+// MPMCQueue is a bounded lock-free queue for any number of producers and
+// consumers (D. Vyukov's design). Each slot carries a sequence number:
+// seq == pos means free for the push at pos, seq == pos+1 means filled for
+// the pop at pos.
+type MPMCQueue[T any] struct {
+	slots   []mpmcSlot[T]
+	mask    uint64
+	_       [cacheLineSize]byte
+	enqueue atomic.Uint64
+	_       [cacheLineSize - 8]byte
+	dequeue atomic.Uint64
+	_       [cacheLineSize - 8]byte
+}
+
+func NewMPMCQueue[T any](size int) *MPMCQueue[T] {
+	size = roundUpPowerOfTwo(size)
+	q := &MPMCQueue[T]{slots: make([]mpmcSlot[T], size), mask: uint64(size - 1)}
+	for i := range q.slots {
+		q.slots[i].seq.Store(uint64(i))
+	}
+	return q
+}
+
+func (q *MPMCQueue[T]) Push(value T) bool {
+	pos := q.enqueue.Load()
+	for {
+		slot := &q.slots[pos&q.mask]
+		diff := int64(slot.seq.Load() - pos)
+		switch {
+		case diff == 0:
+			if q.enqueue.CompareAndSwap(pos, pos+1) {
+				slot.value = value
+				slot.seq.Store(pos + 1)
+				return true
+			}
+			pos = q.enqueue.Load()
+		case diff < 0: // slot still holds the value from one lap ago
+			return false
+		default: // another producer took pos
+			pos = q.enqueue.Load()
+		}
+	}
+}
+
+func (q *MPMCQueue[T]) Pop() (T, bool) {
+	var zero T
+	pos := q.dequeue.Load()
+	for {
+		slot := &q.slots[pos&q.mask]
+		diff := int64(slot.seq.Load() - (pos + 1))
+		switch {
+		case diff == 0:
+			if q.dequeue.CompareAndSwap(pos, pos+1) {
+				value := slot.value
+				slot.value = zero
+				slot.seq.Store(pos + q.mask + 1)
+				return value, true
+			}
+			pos = q.dequeue.Load()
+		case diff < 0: // not filled yet
+			return zero, false
+		default:
+			pos = q.dequeue.Load()
+		}
+	}
+}
+
+func (q *MPMCQueue[T]) Cap() int {
+	return len(q.slots)
+}
+
+func TestRoundUpPowerOfTwo(t *testing.T) {
+	assert.Equal(t, 1, roundUpPowerOfTwo(0))
+	assert.Equal(t, 1, roundUpPowerOfTwo(1))
+	assert.Equal(t, 2, roundUpPowerOfTwo(2))
+	assert.Equal(t, 4, roundUpPowerOfTwo(3))
+	assert.Equal(t, 1024, roundUpPowerOfTwo(1000))
+	assert.Equal(t, 1024, roundUpPowerOfTwo(1024))
+}
+
+type ring[T any] interface {
+	Push(value T) bool
+	Pop() (T, bool)
+	Cap() int
+}
+
+func testRingSequential(t *testing.T, queue ring[int]) {
+	assert.Equal(t, 4, queue.Cap())
+
+	_, ok := queue.Pop()
+	assert.False(t, ok)
+
+	// several laps over the ring
+	for lap := 0; lap < 3; lap++ {
+		for i := 0; i < 4; i++ {
+			assert.True(t, queue.Push(lap*10+i))
+		}
+		assert.False(t, queue.Push(-1))
+		for i := 0; i < 4; i++ {
+			value, ok := queue.Pop()
+			assert.True(t, ok)
+			assert.Equal(t, lap*10+i, value)
+		}
+		_, ok = queue.Pop()
+		assert.False(t, ok)
+	}
+}
+
+func TestSPSCQueue(t *testing.T) {
+	testRingSequential(t, NewSPSCQueue[int](3))
+
+	const count = 10000
+	queue := NewSPSCQueue[int](16)
+	go func() {
+		for i := 0; i < count; i++ {
+			for !queue.Push(i) {
+				runtime.Gosched()
+			}
+		}
+	}()
+	for i := 0; i < count; i++ {
+		value, ok := queue.Pop()
+		for !ok {
+			runtime.Gosched()
+			value, ok = queue.Pop()
+		}
+		assert.Equal(t, i, value)
+	}
+}
+
+func TestMPMCQueue(t *testing.T) {
+	testRingSequential(t, NewMPMCQueue[int](4))
+
+	const workers = 4
+	const perWorker = 2000
+	queue := NewMPMCQueue[int](32)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				for !queue.Push(w*perWorker + i) {
+					runtime.Gosched()
+				}
+			}
+		}(w)
+	}
+
+	results := make(chan []int, workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			received := make([]int, 0, perWorker)
+			for len(received) < perWorker {
+				if value, ok := queue.Pop(); ok {
+					received = append(received, value)
+				} else {
+					runtime.Gosched()
+				}
+			}
+			results <- received
+		}()
+	}
+	wg.Wait()
+
+	var all []int
+	for w := 0; w < workers; w++ {
+		all = append(all, <-results...)
+	}
+	sort.Ints(all)
+	for i, value := range all {
+		assert.Equal(t, i, value)
+	}
+}
+
+type mutexQueue[T any] struct {
+	mu    sync.Mutex
+	queue CircularQueue[T]
+}
+
+func (q *mutexQueue[T]) Push(value T) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queue.Push(value)
+}
+
+func (q *mutexQueue[T]) Pop() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queue.Pop()
+}
+
+func (q *mutexQueue[T]) Cap() int {
+	return cap(q.queue.values)
+}
+
+type chanQueue[T any] chan T
+
+func (q chanQueue[T]) Push(value T) bool {
+	select {
+	case q <- value:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q chanQueue[T]) Pop() (T, bool) {
+	select {
+	case value := <-q:
+		return value, true
+	default:
+		var zero T
+		return zero, false
+	}
+}
+
+func (q chanQueue[T]) Cap() int {
+	return cap(q)
+}
+
+func benchmarkProducerConsumer(b *testing.B, queue ring[int]) {
+	go func() {
+		for i := 0; i < b.N; i++ {
+			for !queue.Push(i) {
+				runtime.Gosched()
+			}
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		for {
+			if _, ok := queue.Pop(); ok {
+				break
+			}
+			runtime.Gosched()
+		}
+	}
+}
+
+func benchmarkParallel(b *testing.B, queue ring[int]) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if queue.Push(1) {
+				queue.Pop()
+			}
+		}
+	})
+}
+
+func BenchmarkRingBuffers(b *testing.B) {
+	const size = 1024
+
+	b.Run("spsc/1p1c", func(b *testing.B) {
+		benchmarkProducerConsumer(b, NewSPSCQueue[int](size))
+	})
+	b.Run("mpmc/1p1c", func(b *testing.B) {
+		benchmarkProducerConsumer(b, NewMPMCQueue[int](size))
+	})
+	b.Run("mutex/1p1c", func(b *testing.B) {
+		benchmarkProducerConsumer(b, &mutexQueue[int]{queue: NewCircularQueue[int](size)})
+	})
+	b.Run("chan/1p1c", func(b *testing.B) {
+		benchmarkProducerConsumer(b, make(chanQueue[int], size))
+	})
+
+	b.Run("mpmc/parallel", func(b *testing.B) {
+		benchmarkParallel(b, NewMPMCQueue[int](size))
+	})
+	b.Run("mutex/parallel", func(b *testing.B) {
+		benchmarkParallel(b, &mutexQueue[int]{queue: NewCircularQueue[int](size)})
+	})
+	b.Run("chan/parallel", func(b *testing.B) {
+		benchmarkParallel(b, make(chanQueue[int], size))
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// NewOverwritingCircularQueue keeps the latest size values: Push on a full
+// queue drops the front element instead of failing.
+func NewOverwritingCircularQueue[T any](size int) CircularQueue[T] {
+	queue := NewCircularQueue[T](size)
+	queue.overwrite = true
+	return queue
+}
+
+// PushOverwrite pushes value in either mode, evicting the front element of
+// a full queue. It reports the evicted value; a zero-size queue evicts the
+// pushed value itself.
+func (q *CircularQueue[T]) PushOverwrite(value T) (T, bool) {
+	if cap(q.values) == 0 {
+		q.overwrites++
+		return value, true
+	}
+	if !q.Full() {
+		q.Push(value)
+		var zero T
+		return zero, false
+	}
+	evicted, _ := q.Pop()
+	q.Push(value)
+	q.overwrites++
+	return evicted, true
+}
+
+func (q *CircularQueue[T]) Overwrites() int {
+	return q.overwrites
+}
+
+func TestOverwritingCircularQueue(t *testing.T) {
+	queue := NewOverwritingCircularQueue[int](3)
+
+	assert.True(t, queue.Push(1))
+	assert.True(t, queue.Push(2))
+	assert.True(t, queue.Push(3))
+	assert.True(t, queue.Full())
+	assert.Equal(t, 0, queue.Overwrites())
+
+	assert.True(t, queue.Push(4))
+	assert.True(t, queue.Push(5))
+	assert.True(t, reflect.DeepEqual([]int{4, 5, 3}, queue.values))
+	assert.Equal(t, 2, queue.Overwrites())
+
+	front, _ := queue.Front()
+	assert.Equal(t, 3, front)
+	back, _ := queue.Back()
+	assert.Equal(t, 5, back)
+
+	evicted, ok := queue.PushOverwrite(6)
+	assert.True(t, ok)
+	assert.Equal(t, 3, evicted)
+	assert.Equal(t, 3, queue.Overwrites())
+
+	queue.Pop()
+	_, ok = queue.PushOverwrite(7)
+	assert.False(t, ok)
+	assert.Equal(t, 3, queue.Overwrites())
+
+	var values []int
+	for !queue.Empty() {
+		value, _ := queue.Pop()
+		values = append(values, value)
+	}
+	assert.Equal(t, []int{5, 6, 7}, values)
+}
+
+func TestCircularQueuePushOverwrite(t *testing.T) {
+	// a bounded queue keeps failing Push but may be overwritten explicitly
+	queue := NewCircularQueue[string](2)
+	assert.True(t, queue.Push("a"))
+	assert.True(t, queue.Push("b"))
+	assert.False(t, queue.Push("c"))
+
+	evicted, ok := queue.PushOverwrite("c")
+	assert.True(t, ok)
+	assert.Equal(t, "a", evicted)
+	assert.Equal(t, 1, queue.Overwrites())
+	assert.False(t, queue.Push("d"))
+
+	empty := NewOverwritingCircularQueue[string](0)
+	assert.True(t, empty.Push("x"))
+	evicted, ok = empty.PushOverwrite("y")
+	assert.True(t, ok)
+	assert.Equal(t, "y", evicted)
+	assert.Equal(t, 2, empty.Overwrites())
+	assert.True(t, empty.Empty())
+}
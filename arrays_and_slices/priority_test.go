@@ -0,0 +1,288 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// PQItem is a handle to a value stored in a PriorityQueue. It stays valid
+// until the value is popped or removed.
+type PQItem[T any] struct {
+	value T
+	index int // position in the heap, -1 once removed
+}
+
+func (item *PQItem[T]) Value() T {
+	return item.value
+}
+
+// PriorityQueue is a binary min-heap ordered by less; Pop returns the
+// smallest value.
+type PriorityQueue[T any] struct {
+	items []*PQItem[T]
+	less  func(a, b T) bool
+}
+
+func NewPriorityQueue[T any](less func(a, b T) bool) *PriorityQueue[T] {
+	return &PriorityQueue[T]{less: less}
+}
+
+// NewPriorityQueueFromSlice heapifies values in O(n) and returns handles in
+// the order of values.
+func NewPriorityQueueFromSlice[T any](values []T, less func(a, b T) bool) (*PriorityQueue[T], []*PQItem[T]) {
+	q := &PriorityQueue[T]{items: make([]*PQItem[T], len(values)), less: less}
+	handles := make([]*PQItem[T], len(values))
+	for i, value := range values {
+		q.items[i] = &PQItem[T]{value: value, index: i}
+		handles[i] = q.items[i]
+	}
+	for i := len(q.items)/2 - 1; i >= 0; i-- {
+		q.down(i)
+	}
+	return q, handles
+}
+
+func (q *PriorityQueue[T]) Len() int {
+	return len(q.items)
+}
+
+func (q *PriorityQueue[T]) Empty() bool {
+	return len(q.items) == 0
+}
+
+func (q *PriorityQueue[T]) Push(value T) *PQItem[T] {
+	item := &PQItem[T]{value: value, index: len(q.items)}
+	q.items = append(q.items, item)
+	q.up(item.index)
+	return item
+}
+
+func (q *PriorityQueue[T]) Peek() (T, bool) {
+	if len(q.items) == 0 {
+		var zero T
+		return zero, false
+	}
+	return q.items[0].value, true
+}
+
+func (q *PriorityQueue[T]) Pop() (T, bool) {
+	if len(q.items) == 0 {
+		var zero T
+		return zero, false
+	}
+	return q.removeAt(0), true
+}
+
+// Remove deletes the value behind item; it returns false for a handle that
+// was already popped or removed.
+func (q *PriorityQueue[T]) Remove(item *PQItem[T]) (T, bool) {
+	if !q.owns(item) {
+		var zero T
+		return zero, false
+	}
+	return q.removeAt(item.index), true
+}
+
+// Update replaces the value behind item and restores the heap order.
+func (q *PriorityQueue[T]) Update(item *PQItem[T], value T) bool {
+	if !q.owns(item) {
+		return false
+	}
+	item.value = value
+	q.fix(item.index)
+	return true
+}
+
+// Fix restores the heap order after the value behind item, e.g. a pointed
+// to struct, was changed in place.
+func (q *PriorityQueue[T]) Fix(item *PQItem[T]) bool {
+	if !q.owns(item) {
+		return false
+	}
+	q.fix(item.index)
+	return true
+}
+
+func (q *PriorityQueue[T]) owns(item *PQItem[T]) bool {
+	return item != nil && item.index >= 0 && item.index < len(q.items) && q.items[item.index] == item
+}
+
+func (q *PriorityQueue[T]) fix(i int) {
+	if !q.down(i) {
+		q.up(i)
+	}
+}
+
+func (q *PriorityQueue[T]) removeAt(i int) T {
+	item := q.items[i]
+	last := len(q.items) - 1
+	if i != last {
+		q.swap(i, last)
+	}
+	q.items[last] = nil
+	q.items = q.items[:last]
+	if i != last {
+		q.fix(i)
+	}
+	item.index = -1
+	return item.value
+}
+
+func (q *PriorityQueue[T]) swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *PriorityQueue[T]) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !q.less(q.items[i].value, q.items[parent].value) {
+			return
+		}
+		q.swap(i, parent)
+		i = parent
+	}
+}
+
+// down reports whether the element moved.
+func (q *PriorityQueue[T]) down(i int) bool {
+	start := i
+	for {
+		smallest := i
+		left, right := 2*i+1, 2*i+2
+		if left < len(q.items) && q.less(q.items[left].value, q.items[smallest].value) {
+			smallest = left
+		}
+		if right < len(q.items) && q.less(q.items[right].value, q.items[smallest].value) {
+			smallest = right
+		}
+		if smallest == i {
+			return i != start
+		}
+		q.swap(i, smallest)
+		i = smallest
+	}
+}
+
+type task struct {
+	name     string
+	priority int
+}
+
+func TestPriorityQueue(t *testing.T) {
+	queue := NewPriorityQueue(func(a, b task) bool {
+		return a.priority < b.priority
+	})
+
+	_, ok := queue.Peek()
+	assert.False(t, ok)
+	_, ok = queue.Pop()
+	assert.False(t, ok)
+
+	queue.Push(task{"low", 5})
+	urgent := queue.Push(task{"urgent", 1})
+	medium := queue.Push(task{"medium", 3})
+	queue.Push(task{"background", 9})
+	assert.Equal(t, 4, queue.Len())
+
+	top, ok := queue.Peek()
+	assert.True(t, ok)
+	assert.Equal(t, "urgent", top.name)
+
+	assert.True(t, queue.Update(medium, task{"medium", 0}))
+	top, _ = queue.Peek()
+	assert.Equal(t, "medium", top.name)
+
+	removed, ok := queue.Remove(urgent)
+	assert.True(t, ok)
+	assert.Equal(t, "urgent", removed.name)
+	_, ok = queue.Remove(urgent)
+	assert.False(t, ok)
+	assert.False(t, queue.Update(urgent, task{}))
+
+	var order []string
+	for !queue.Empty() {
+		value, _ := queue.Pop()
+		order = append(order, value.name)
+	}
+	assert.Equal(t, []string{"medium", "low", "background"}, order)
+	assert.False(t, queue.Fix(medium))
+}
+
+func TestPriorityQueueFix(t *testing.T) {
+	queue := NewPriorityQueue(func(a, b *task) bool {
+		return a.priority < b.priority
+	})
+	tasks := []*task{{"a", 1}, {"b", 2}, {"c", 3}}
+	var handles []*PQItem[*task]
+	for _, value := range tasks {
+		handles = append(handles, queue.Push(value))
+	}
+
+	tasks[2].priority = 0
+	assert.True(t, queue.Fix(handles[2]))
+	tasks[0].priority = 10
+	assert.True(t, queue.Fix(handles[0]))
+
+	var order []string
+	for !queue.Empty() {
+		value, _ := queue.Pop()
+		order = append(order, value.name)
+	}
+	assert.Equal(t, []string{"c", "b", "a"}, order)
+
+	other := NewPriorityQueue(func(a, b *task) bool { return false })
+	other.Push(tasks[0])
+	assert.False(t, other.Fix(handles[0]))
+	assert.False(t, other.Fix(nil))
+}
+
+func TestPriorityQueueFromSlice(t *testing.T) {
+	random := rand.New(rand.NewSource(3))
+	values := make([]int, 200)
+	for i := range values {
+		values[i] = random.Intn(1000)
+	}
+
+	queue, handles := NewPriorityQueueFromSlice(values, func(a, b int) bool { return a < b })
+	assert.Equal(t, len(values), len(handles))
+	for i, handle := range handles {
+		assert.Equal(t, values[i], handle.Value())
+	}
+
+	// remove and update random handles, then check the pop order
+	expected := append([]int(nil), values...)
+	for i := 0; i < 50; i++ {
+		j := random.Intn(len(handles))
+		if random.Intn(2) == 0 {
+			if _, ok := queue.Remove(handles[j]); ok {
+				expected[j] = -1
+			}
+		} else if queue.Update(handles[j], random.Intn(1000)) {
+			expected[j] = handles[j].Value()
+		}
+	}
+
+	var remaining []int
+	for _, value := range expected {
+		if value >= 0 {
+			remaining = append(remaining, value)
+		}
+	}
+	sort.Ints(remaining)
+
+	var popped []int
+	for !queue.Empty() {
+		value, _ := queue.Pop()
+		popped = append(popped, value)
+	}
+	assert.Equal(t, remaining, popped)
+
+	empty, handles := NewPriorityQueueFromSlice(nil, func(a, b int) bool { return a < b })
+	assert.True(t, empty.Empty())
+	assert.Empty(t, handles)
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var ErrLimitExceeded = errors.New("rate limit would exceed context deadline")
+
+type RateLimiter interface {
+	Allow() bool
+	Reserve() *Reservation
+	Wait(ctx context.Context) error
+}
+
+// Reservation is a slot granted to act after Delay. A reservation that is
+// not OK was never granted and consumed nothing.
+type Reservation struct {
+	ok     bool
+	delay  time.Duration
+	cancel func()
+}
+
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+func (r *Reservation) Delay() time.Duration {
+	return r.delay
+}
+
+// Cancel hands the slot back if its time has not come yet. Only the latest
+// sliding-log reservation can be handed back, since later ones were
+// scheduled after it.
+func (r *Reservation) Cancel() {
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+}
+
+// wait reserves a slot that fits before the context deadline and sleeps
+// until it is due, returning the slot when the context ends first.
+func wait(ctx context.Context, clock TimerClock, reserve func(maxDelay time.Duration) *Reservation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	maxDelay := time.Duration(math.MaxInt64)
+	if deadline, ok := ctx.Deadline(); ok {
+		maxDelay = time.Until(deadline)
+	}
+	r := reserve(maxDelay)
+	if !r.ok {
+		return ErrLimitExceeded
+	}
+	if r.delay == 0 {
+		return nil
+	}
+	select {
+	case <-clock.After(r.delay):
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+// SlidingLogLimiter allows limit requests in any window-long interval. The
+// log keeps the timestamps of the last limit requests, oldest at the front;
+// a request is allowed once the front has left the window. Reservations
+// log their future time, so the log stays sorted.
+type SlidingLogLimiter struct {
+	mu     sync.Mutex
+	log    CircularQueue[time.Time]
+	window time.Duration
+	clock  TimerClock
+}
+
+// NewSlidingLogLimiter uses the system clock when clock is nil; a limit
+// below one allows nothing.
+func NewSlidingLogLimiter(limit int, window time.Duration, clock TimerClock) *SlidingLogLimiter {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	return &SlidingLogLimiter{log: NewCircularQueue[time.Time](limit), window: window, clock: clock}
+}
+
+func (l *SlidingLogLimiter) Allow() bool {
+	return l.reserve(0).ok
+}
+
+func (l *SlidingLogLimiter) Reserve() *Reservation {
+	return l.reserve(math.MaxInt64)
+}
+
+func (l *SlidingLogLimiter) Wait(ctx context.Context) error {
+	return wait(ctx, l.clock, l.reserve)
+}
+
+func (l *SlidingLogLimiter) reserve(maxDelay time.Duration) *Reservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if cap(l.log.values) == 0 {
+		return &Reservation{}
+	}
+	expired := now.Add(-l.window)
+	for front, ok := l.log.Front(); ok && !front.After(expired); front, ok = l.log.Front() {
+		l.log.Pop()
+	}
+	if !l.log.Full() {
+		l.log.Push(now)
+		return &Reservation{ok: true}
+	}
+
+	front, _ := l.log.Front()
+	at := front.Add(l.window)
+	delay := at.Sub(now)
+	if delay > maxDelay {
+		return &Reservation{}
+	}
+	l.log.Pop()
+	l.log.Push(at)
+	return &Reservation{ok: true, delay: delay, cancel: func() {
+		l.unreserve(front, at)
+	}}
+}
+
+// unreserve drops at from the back of the log and puts the entry it
+// replaced back in front.
+func (l *SlidingLogLimiter) unreserve(replaced, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	back, ok := l.log.Back()
+	if !ok || !back.Equal(at) || !l.clock.Now().Before(at) {
+		return
+	}
+	q := &l.log
+	q.values[(q.idx+q.sz-1)%cap(q.values)] = time.Time{}
+	q.idx = (q.idx - 1 + cap(q.values)) % cap(q.values)
+	q.values[q.idx] = replaced
+}
+
+// TokenBucket holds up to burst tokens and refills rate tokens per second;
+// each request takes one. Refills are computed lazily from the time of the
+// last update, and reservations may drive the balance negative.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	clock  TimerClock
+}
+
+// NewTokenBucket starts with a full bucket and uses the system clock when
+// clock is nil.
+func NewTokenBucket(rate float64, burst int, clock TimerClock) *TokenBucket {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: clock.Now(), clock: clock}
+}
+
+func (b *TokenBucket) Allow() bool {
+	return b.reserve(0).ok
+}
+
+func (b *TokenBucket) Reserve() *Reservation {
+	return b.reserve(math.MaxInt64)
+}
+
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	return wait(ctx, b.clock, b.reserve)
+}
+
+func (b *TokenBucket) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(b.clock.Now())
+	return b.tokens
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+func (b *TokenBucket) reserve(maxDelay time.Duration) *Reservation {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	b.refill(now)
+	if b.burst < 1 {
+		return &Reservation{}
+	}
+	tokens := b.tokens - 1
+	var delay time.Duration
+	if tokens < 0 {
+		if b.rate <= 0 {
+			return &Reservation{}
+		}
+		seconds := -tokens / b.rate
+		if seconds > maxDelay.Seconds() {
+			return &Reservation{}
+		}
+		delay = time.Duration(math.Ceil(seconds * float64(time.Second)))
+	}
+	b.tokens = tokens
+	if delay == 0 {
+		return &Reservation{ok: true}
+	}
+	at := now.Add(delay)
+	return &Reservation{ok: true, delay: delay, cancel: func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		now := b.clock.Now()
+		if now.Before(at) {
+			b.refill(now)
+			b.tokens = math.Min(b.burst, b.tokens+1)
+		}
+	}}
+}
+
+var (
+	_ RateLimiter = (*SlidingLogLimiter)(nil)
+	_ RateLimiter = (*TokenBucket)(nil)
+)
+
+// waitTimer blocks until some goroutine sleeps on the fake clock.
+func waitTimer(clock *fakeClock) {
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSlidingLogLimiter(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewSlidingLogLimiter(3, time.Minute, clock)
+
+	assert.True(t, limiter.Allow())
+	clock.Advance(10 * time.Second)
+	assert.True(t, limiter.Allow())
+	assert.True(t, limiter.Allow())
+	assert.False(t, limiter.Allow())
+
+	// the first request leaves the window
+	clock.Advance(50 * time.Second)
+	assert.True(t, limiter.Allow())
+	assert.False(t, limiter.Allow())
+
+	// the next slot opens when the requests at 10s leave the window
+	r := limiter.Reserve()
+	assert.True(t, r.OK())
+	assert.Equal(t, 10*time.Second, r.Delay())
+	r = limiter.Reserve()
+	assert.Equal(t, 10*time.Second, r.Delay())
+	r = limiter.Reserve()
+	assert.Equal(t, time.Minute, r.Delay())
+
+	// cancelling the latest reservation frees its slot, once
+	r.Cancel()
+	r.Cancel()
+	r = limiter.Reserve()
+	assert.Equal(t, time.Minute, r.Delay())
+
+	clock.Advance(2 * time.Minute)
+	assert.True(t, limiter.Allow())
+
+	never := NewSlidingLogLimiter(0, time.Second, clock)
+	assert.False(t, never.Allow())
+	assert.False(t, never.Reserve().OK())
+	assert.Equal(t, ErrLimitExceeded, never.Wait(context.Background()))
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := newFakeClock()
+	bucket := NewTokenBucket(2, 3, clock)
+
+	assert.True(t, bucket.Allow())
+	assert.True(t, bucket.Allow())
+	assert.True(t, bucket.Allow())
+	assert.False(t, bucket.Allow())
+
+	clock.Advance(500 * time.Millisecond)
+	assert.True(t, bucket.Allow())
+	assert.False(t, bucket.Allow())
+
+	// refills stop at burst
+	clock.Advance(time.Hour)
+	assert.Equal(t, 3.0, bucket.Tokens())
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), bucket.Reserve().Delay())
+	}
+	r := bucket.Reserve()
+	assert.True(t, r.OK())
+	assert.Equal(t, 500*time.Millisecond, r.Delay())
+	r = bucket.Reserve()
+	assert.Equal(t, time.Second, r.Delay())
+	assert.Equal(t, -2.0, bucket.Tokens())
+
+	r.Cancel()
+	assert.Equal(t, -1.0, bucket.Tokens())
+	assert.Equal(t, time.Second, bucket.Reserve().Delay())
+
+	empty := NewTokenBucket(0, 1, clock)
+	assert.True(t, empty.Allow())
+	assert.False(t, empty.Reserve().OK())
+	assert.False(t, NewTokenBucket(10, 0, clock).Allow())
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	limiters := map[string]func(clock *fakeClock) RateLimiter{
+		"sliding log": func(clock *fakeClock) RateLimiter {
+			return NewSlidingLogLimiter(1, time.Second, clock)
+		},
+		"token bucket": func(clock *fakeClock) RateLimiter {
+			return NewTokenBucket(1, 1, clock)
+		},
+	}
+	for name, newLimiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			limiter := newLimiter(clock)
+			ctx := context.Background()
+			assert.NoError(t, limiter.Wait(ctx))
+
+			done := make(chan error)
+			go func() {
+				done <- limiter.Wait(ctx)
+			}()
+			waitTimer(clock)
+			clock.Advance(time.Second)
+			assert.NoError(t, <-done)
+
+			// a cancelled wait hands its slot back
+			cancelCtx, cancel := context.WithCancel(ctx)
+			go func() {
+				done <- limiter.Wait(cancelCtx)
+			}()
+			waitTimer(clock)
+			cancel()
+			assert.Equal(t, context.Canceled, <-done)
+			assert.Equal(t, time.Second, limiter.Reserve().Delay())
+
+			deadlineCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+			defer cancel()
+			assert.Equal(t, ErrLimitExceeded, limiter.Wait(deadlineCtx))
+			assert.Equal(t, context.Canceled, limiter.Wait(cancelCtx))
+		})
+	}
+}
+
+func TestRateLimiterConcurrent(t *testing.T) {
+	clock := newFakeClock()
+	limiters := []RateLimiter{
+		NewSlidingLogLimiter(100, time.Second, clock),
+		NewTokenBucket(1, 100, clock),
+	}
+	for _, limiter := range limiters {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		allowed := 0
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					if limiter.Allow() {
+						mu.Lock()
+						allowed++
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 100, allowed)
+	}
+}
+
+func TestRateLimiterSystemClock(t *testing.T) {
+	limiter := NewTokenBucket(1000, 1, nil)
+	assert.True(t, limiter.Allow())
+	assert.NoError(t, limiter.Wait(context.Background()))
+	assert.True(t, NewSlidingLogLimiter(1, time.Millisecond, nil).Allow())
+}
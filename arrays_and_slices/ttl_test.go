@@ -0,0 +1,217 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+// TimerClock can also wait, for code that sleeps until a computed time.
+type TimerClock interface {
+	Clock
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type ttlEntry[T any] struct {
+	value T
+	at    time.Time
+}
+
+// TTLQueue is a bounded FIFO whose elements live for ttl after Push.
+// Expired elements are dropped from the front on every access; since
+// pushes are timestamped in order, the front is always the oldest.
+type TTLQueue[T any] struct {
+	values CircularQueue[ttlEntry[T]]
+	ttl    time.Duration
+	clock  Clock
+}
+
+// NewTTLQueue uses the system clock when clock is nil.
+func NewTTLQueue[T any](size int, ttl time.Duration, clock Clock) *TTLQueue[T] {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	return &TTLQueue[T]{values: NewCircularQueue[ttlEntry[T]](size), ttl: ttl, clock: clock}
+}
+
+func (q *TTLQueue[T]) expire() time.Time {
+	now := q.clock.Now()
+	for front, ok := q.values.Front(); ok && now.Sub(front.at) >= q.ttl; front, ok = q.values.Front() {
+		q.values.Pop()
+	}
+	return now
+}
+
+func (q *TTLQueue[T]) Push(value T) bool {
+	now := q.expire()
+	return q.values.Push(ttlEntry[T]{value, now})
+}
+
+func (q *TTLQueue[T]) Pop() (T, bool) {
+	q.expire()
+	entry, ok := q.values.Pop()
+	return entry.value, ok
+}
+
+func (q *TTLQueue[T]) Front() (T, bool) {
+	q.expire()
+	entry, ok := q.values.Front()
+	return entry.value, ok
+}
+
+func (q *TTLQueue[T]) Back() (T, bool) {
+	q.expire()
+	entry, ok := q.values.Back()
+	return entry.value, ok
+}
+
+func (q *TTLQueue[T]) Len() int {
+	q.expire()
+	return q.values.Len()
+}
+
+func (q *TTLQueue[T]) Empty() bool {
+	return q.Len() == 0
+}
+
+func (q *TTLQueue[T]) Full() bool {
+	q.expire()
+	return q.values.Full()
+}
+
+// Range calls fn for live elements from front to back until fn returns
+// false.
+func (q *TTLQueue[T]) Range(fn func(i int, value T) bool) {
+	q.expire()
+	q.values.Range(func(i int, entry ttlEntry[T]) bool {
+		return fn(i, entry.value)
+	})
+}
+
+type fakeTimer struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// fakeClock only moves on Advance, which also fires due After channels.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, fakeTimer{c.now.Add(d), ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.deadline.After(c.now) {
+			pending = append(pending, timer)
+		} else {
+			timer.ch <- c.now
+		}
+	}
+	c.timers = pending
+}
+
+func (c *fakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func TestTTLQueue(t *testing.T) {
+	clock := newFakeClock()
+	queue := NewTTLQueue[string](3, time.Minute, clock)
+
+	assert.True(t, queue.Push("a"))
+	clock.Advance(20 * time.Second)
+	assert.True(t, queue.Push("b"))
+	clock.Advance(20 * time.Second)
+	assert.True(t, queue.Push("c"))
+	assert.False(t, queue.Push("d"))
+	assert.True(t, queue.Full())
+
+	front, _ := queue.Front()
+	assert.Equal(t, "a", front)
+
+	// "a" reaches its ttl
+	clock.Advance(20 * time.Second)
+	front, _ = queue.Front()
+	assert.Equal(t, "b", front)
+	assert.Equal(t, 2, queue.Len())
+	assert.True(t, queue.Push("d"))
+
+	var live []string
+	queue.Range(func(_ int, value string) bool {
+		live = append(live, value)
+		return true
+	})
+	assert.Equal(t, []string{"b", "c", "d"}, live)
+
+	value, ok := queue.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, "b", value)
+
+	clock.Advance(50 * time.Second)
+	back, ok := queue.Back()
+	assert.True(t, ok)
+	assert.Equal(t, "d", back)
+	assert.Equal(t, 1, queue.Len())
+
+	clock.Advance(time.Hour)
+	assert.True(t, queue.Empty())
+	_, ok = queue.Front()
+	assert.False(t, ok)
+	_, ok = queue.Back()
+	assert.False(t, ok)
+	_, ok = queue.Pop()
+	assert.False(t, ok)
+}
+
+func TestTTLQueueSystemClock(t *testing.T) {
+	queue := NewTTLQueue[int](2, time.Hour, nil)
+	assert.True(t, queue.Push(1))
+	front, ok := queue.Front()
+	assert.True(t, ok)
+	assert.Equal(t, 1, front)
+}
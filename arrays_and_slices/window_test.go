@@ -0,0 +1,195 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+		~float32 | ~float64
+}
+
+type windowEntry[T Number] struct {
+	seq   int
+	value T
+}
+
+// SlidingWindow keeps statistics over the last size pushed values. Mean and
+// variance are updated with Welford's method, min and max come from the
+// fronts of monotonic deques, so every Push is O(1) amortized.
+type SlidingWindow[T Number] struct {
+	values CircularQueue[T]
+	seq    int
+	sum    T
+	mean   float64
+	m2     float64               // sum of squared deviations from mean
+	mins   Deque[windowEntry[T]] // increasing values
+	maxs   Deque[windowEntry[T]] // decreasing values
+}
+
+// NewSlidingWindow creates a window over the last size values; sizes below
+// one are treated as one.
+func NewSlidingWindow[T Number](size int) *SlidingWindow[T] {
+	if size < 1 {
+		size = 1
+	}
+	return &SlidingWindow[T]{values: NewOverwritingCircularQueue[T](size)}
+}
+
+func (w *SlidingWindow[T]) Push(value T) {
+	evicted, full := w.values.PushOverwrite(value)
+	x := float64(value)
+	w.sum += value
+	if full {
+		old := float64(evicted)
+		w.sum -= evicted
+		mean := w.mean + (x-old)/float64(w.values.sz)
+		w.m2 += (x - old) * (x - mean + old - w.mean)
+		w.mean = mean
+	} else {
+		delta := x - w.mean
+		w.mean += delta / float64(w.values.sz)
+		w.m2 += delta * (x - w.mean)
+	}
+
+	w.seq++
+	entry := windowEntry[T]{w.seq, value}
+	for back, ok := w.mins.Back(); ok && back.value >= value; back, ok = w.mins.Back() {
+		w.mins.PopBack()
+	}
+	w.mins.PushBack(entry)
+	for back, ok := w.maxs.Back(); ok && back.value <= value; back, ok = w.maxs.Back() {
+		w.maxs.PopBack()
+	}
+	w.maxs.PushBack(entry)
+
+	oldest := w.seq - w.values.sz
+	if front, _ := w.mins.Front(); front.seq <= oldest {
+		w.mins.PopFront()
+	}
+	if front, _ := w.maxs.Front(); front.seq <= oldest {
+		w.maxs.PopFront()
+	}
+}
+
+func (w *SlidingWindow[T]) Len() int {
+	return w.values.sz
+}
+
+func (w *SlidingWindow[T]) Sum() T {
+	return w.sum
+}
+
+func (w *SlidingWindow[T]) Mean() float64 {
+	return w.mean
+}
+
+// Variance returns the population variance of the window.
+func (w *SlidingWindow[T]) Variance() float64 {
+	if w.values.sz == 0 || w.m2 < 0 {
+		return 0
+	}
+	return w.m2 / float64(w.values.sz)
+}
+
+func (w *SlidingWindow[T]) Min() (T, bool) {
+	front, ok := w.mins.Front()
+	return front.value, ok
+}
+
+func (w *SlidingWindow[T]) Max() (T, bool) {
+	front, ok := w.maxs.Front()
+	return front.value, ok
+}
+
+func TestSlidingWindow(t *testing.T) {
+	window := NewSlidingWindow[int](3)
+
+	_, ok := window.Min()
+	assert.False(t, ok)
+	_, ok = window.Max()
+	assert.False(t, ok)
+	assert.Equal(t, 0.0, window.Variance())
+
+	window.Push(4)
+	window.Push(1)
+	assert.Equal(t, 2, window.Len())
+	assert.Equal(t, 5, window.Sum())
+	assert.Equal(t, 2.5, window.Mean())
+	assert.InDelta(t, 2.25, window.Variance(), 1e-9)
+
+	window.Push(7)
+	window.Push(3)
+	// window is 1 7 3
+	assert.Equal(t, 3, window.Len())
+	assert.Equal(t, 11, window.Sum())
+	assert.InDelta(t, 11.0/3, window.Mean(), 1e-9)
+	assert.InDelta(t, 56.0/9, window.Variance(), 1e-9)
+	lo, _ := window.Min()
+	hi, _ := window.Max()
+	assert.Equal(t, 1, lo)
+	assert.Equal(t, 7, hi)
+
+	window.Push(5)
+	window.Push(6)
+	// window is 3 5 6
+	lo, _ = window.Min()
+	hi, _ = window.Max()
+	assert.Equal(t, 3, lo)
+	assert.Equal(t, 6, hi)
+	assert.Equal(t, 14, window.Sum())
+
+	single := NewSlidingWindow[uint8](0)
+	single.Push(200)
+	single.Push(10)
+	assert.Equal(t, 1, single.Len())
+	assert.Equal(t, uint8(10), single.Sum())
+	max8, _ := single.Max()
+	assert.Equal(t, uint8(10), max8)
+	assert.Equal(t, 0.0, single.Variance())
+}
+
+func TestSlidingWindowRandom(t *testing.T) {
+	const size = 16
+	random := rand.New(rand.NewSource(7))
+	window := NewSlidingWindow[float64](size)
+	var all []float64
+
+	for i := 0; i < 2000; i++ {
+		value := random.NormFloat64()*10 + 100
+		window.Push(value)
+		all = append(all, value)
+
+		last := all
+		if len(last) > size {
+			last = last[len(last)-size:]
+		}
+		sum, lo, hi := 0.0, math.Inf(1), math.Inf(-1)
+		for _, v := range last {
+			sum += v
+			lo = math.Min(lo, v)
+			hi = math.Max(hi, v)
+		}
+		mean := sum / float64(len(last))
+		variance := 0.0
+		for _, v := range last {
+			variance += (v - mean) * (v - mean)
+		}
+		variance /= float64(len(last))
+
+		assert.InDelta(t, sum, window.Sum(), 1e-6)
+		assert.InDelta(t, mean, window.Mean(), 1e-6)
+		assert.InDelta(t, variance, window.Variance(), 1e-6)
+		gotMin, _ := window.Min()
+		gotMax, _ := window.Max()
+		assert.Equal(t, lo, gotMin)
+		assert.Equal(t, hi, gotMax)
+	}
+	assert.LessOrEqual(t, window.mins.Len(), size)
+	assert.LessOrEqual(t, window.maxs.Len(), size)
+}
@@ -1,6 +1,6 @@
 module deep_go
 
-go 1.18
+go 1.20
 
 require github.com/stretchr/testify v1.10.0
 
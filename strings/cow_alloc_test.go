@@ -0,0 +1,177 @@
+package main
+
+import (
+	"math/bits"
+	"sync"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	minSizeClassShift = 6  // 64 B
+	maxSizeClassShift = 20 // 1 MiB
+)
+
+// PoolAllocator recycles storage through one sync.Pool per power-of-two
+// size class. Sizes above the largest class fall back to make and are left
+// to the GC on Free.
+type PoolAllocator struct {
+	pools [maxSizeClassShift - minSizeClassShift + 1]sync.Pool
+}
+
+func NewPoolAllocator() *PoolAllocator {
+	return &PoolAllocator{}
+}
+
+// NewCOWBufferWithAllocator wraps caller-owned data; copies made by Update
+// come from alloc and go back to it when their last reference is closed.
+// Strings returned by String must not outlive Close for such buffers.
+func NewCOWBufferWithAllocator(data []byte, alloc Allocator) COWBuffer {
+	buffer := NewCOWBuffer(data)
+	buffer.alloc = alloc
+	return buffer
+}
+
+func sizeClass(size int) int {
+	if size <= 1<<minSizeClassShift {
+		return 0
+	}
+	return bits.Len(uint(size-1)) - minSizeClassShift
+}
+
+func (a *PoolAllocator) Alloc(size int) []byte {
+	class := sizeClass(size)
+	if class >= len(a.pools) {
+		return make([]byte, size)
+	}
+	if p, ok := a.pools[class].Get().(*[]byte); ok {
+		return (*p)[:size]
+	}
+	return make([]byte, size, 1<<(class+minSizeClassShift))
+}
+
+func (a *PoolAllocator) Free(data []byte) {
+	class := sizeClass(cap(data))
+	if class >= len(a.pools) || cap(data) != 1<<(class+minSizeClassShift) {
+		return
+	}
+	data = data[:cap(data)]
+	a.pools[class].Put(&data)
+}
+
+func TestSizeClass(t *testing.T) {
+	assert.Equal(t, 0, sizeClass(0))
+	assert.Equal(t, 0, sizeClass(64))
+	assert.Equal(t, 1, sizeClass(65))
+	assert.Equal(t, 1, sizeClass(128))
+	assert.Equal(t, 14, sizeClass(1<<20))
+	assert.Equal(t, 15, sizeClass(1<<20+1))
+}
+
+func TestPoolAllocator(t *testing.T) {
+	alloc := NewPoolAllocator()
+
+	small := alloc.Alloc(10)
+	assert.Equal(t, 10, len(small))
+	assert.Equal(t, 64, cap(small))
+
+	medium := alloc.Alloc(100)
+	assert.Equal(t, 100, len(medium))
+	assert.Equal(t, 128, cap(medium))
+
+	huge := alloc.Alloc(1<<20 + 1)
+	assert.Equal(t, 1<<20+1, cap(huge))
+	alloc.Free(huge)
+
+	// foreign capacities are ignored
+	alloc.Free(make([]byte, 100))
+	alloc.Free(medium[:0:100])
+}
+
+func TestCOWBufferWithAllocator(t *testing.T) {
+	alloc := NewPoolAllocator()
+	data := []byte("pooled")
+	buffer := NewCOWBufferWithAllocator(data, alloc)
+	defer buffer.Close()
+
+	clone := buffer.Clone()
+	assert.True(t, clone.Update(0, 'P'))
+	assert.Equal(t, "Pooled", clone.String())
+	assert.Equal(t, 64, cap(clone.data))
+	assert.NotNil(t, clone.block)
+	assert.Nil(t, buffer.block)
+
+	// copies of the copy keep using the allocator
+	second := clone.Clone()
+	assert.True(t, second.Update(1, 'O'))
+	assert.Equal(t, 64, cap(second.data))
+	second.Close()
+
+	clone.Close()
+	assert.Equal(t, "pooled", buffer.String())
+	assert.True(t, unsafe.SliceData(data) == unsafe.SliceData(buffer.data))
+
+	// the caller's storage is never handed to the allocator
+	last := NewCOWBufferWithAllocator([]byte("mine"), alloc)
+	last.Close()
+	assert.Equal(t, 0, *last.refs)
+}
+
+// recordingAllocator remembers what it was given back.
+type recordingAllocator struct {
+	freed [][]byte
+}
+
+func (a *recordingAllocator) Alloc(size int) []byte {
+	return make([]byte, size, 64)
+}
+
+func (a *recordingAllocator) Free(data []byte) {
+	a.freed = append(a.freed, data)
+}
+
+func TestCOWBufferWithAllocatorViewClosesLast(t *testing.T) {
+	alloc := &recordingAllocator{}
+	source := NewCOWBufferWithAllocator([]byte("pooled storage"), alloc)
+	buffer := source.Clone()
+	assert.True(t, buffer.Update(0, 'P'))
+	source.Close()
+	block := unsafe.SliceData(buffer.data)
+
+	view, ok := buffer.Slice(7, 14)
+	assert.True(t, ok)
+	buffer.Close()
+	assert.Empty(t, alloc.freed)
+
+	// the whole block goes back, not the view's sub-slice
+	view.Close()
+	assert.Equal(t, 1, len(alloc.freed))
+	assert.True(t, block == unsafe.SliceData(alloc.freed[0]))
+	assert.Equal(t, 64, cap(alloc.freed[0]))
+}
+
+func benchmarkCOWBufferUpdate(b *testing.B, buffer COWBuffer) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		clone := buffer.Clone()
+		clone.Update(i%len(clone.data), byte(i))
+		clone.Close()
+	}
+}
+
+func BenchmarkCOWBufferUpdate(b *testing.B) {
+	data := make([]byte, 4096)
+
+	b.Run("make", func(b *testing.B) {
+		buffer := NewCOWBuffer(data)
+		defer buffer.Close()
+		benchmarkCOWBufferUpdate(b, buffer)
+	})
+	b.Run("pool", func(b *testing.B) {
+		buffer := NewCOWBufferWithAllocator(data, NewPoolAllocator())
+		defer buffer.Close()
+		benchmarkCOWBufferUpdate(b, buffer)
+	})
+}
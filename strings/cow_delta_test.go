@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	deltaBlockSize = 16
+	deltaHashBase  = 257
+)
+
+const (
+	deltaCopy byte = iota
+	deltaInsert
+)
+
+var (
+	ErrDeltaMismatch = errors.New("delta was computed against another base")
+	ErrCorruptDelta  = errors.New("corrupt delta")
+)
+
+type deltaOp struct {
+	kind   byte
+	offset int    // deltaCopy: offset in the base
+	length int    // deltaCopy: bytes taken from the base
+	data   []byte // deltaInsert: literal bytes
+}
+
+// COWDelta rebuilds a newer version from a base as a sequence of copies
+// from the base and inserted literals.
+type COWDelta struct {
+	oldLen int
+	newLen int
+	ops    []deltaOp
+}
+
+func (d *COWDelta) addCopy(offset, length int) {
+	if n := len(d.ops); n > 0 {
+		last := &d.ops[n-1]
+		if last.kind == deltaCopy && last.offset+last.length == offset {
+			last.length += length
+			return
+		}
+	}
+	d.ops = append(d.ops, deltaOp{kind: deltaCopy, offset: offset, length: length})
+}
+
+func (d *COWDelta) addInsert(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	literal := append([]byte(nil), data...)
+	d.ops = append(d.ops, deltaOp{kind: deltaInsert, length: len(literal), data: literal})
+}
+
+func blockHash(block []byte) uint32 {
+	var h uint32
+	for _, c := range block {
+		h = h*deltaHashBase + uint32(c)
+	}
+	return h
+}
+
+// Diff computes a delta turning b into newer. Blocks of the base are
+// indexed by a rolling hash; every hit is verified and then extended in
+// both directions, so moved and shifted regions are found as well.
+func (b *COWBuffer) Diff(newer *COWBuffer) COWDelta {
+	old, cur := b.data, newer.data
+	delta := COWDelta{oldLen: len(old), newLen: len(cur)}
+
+	index := make(map[uint32][]int, len(old)/deltaBlockSize)
+	for off := 0; off+deltaBlockSize <= len(old); off += deltaBlockSize {
+		h := blockHash(old[off : off+deltaBlockSize])
+		index[h] = append(index[h], off)
+	}
+
+	// weight of the byte leaving the window
+	var outWeight uint32 = 1
+	for i := 1; i < deltaBlockSize; i++ {
+		outWeight *= deltaHashBase
+	}
+
+	literal, i := 0, 0
+	if len(index) == 0 || len(cur) < deltaBlockSize {
+		delta.addInsert(cur)
+		return delta
+	}
+	h := blockHash(cur[:deltaBlockSize])
+	for {
+		match := -1
+		for _, off := range index[h] {
+			if bytes.Equal(old[off:off+deltaBlockSize], cur[i:i+deltaBlockSize]) {
+				match = off
+				break
+			}
+		}
+
+		if match >= 0 {
+			n := deltaBlockSize
+			for match+n < len(old) && i+n < len(cur) && old[match+n] == cur[i+n] {
+				n++
+			}
+			for i > literal && match > 0 && old[match-1] == cur[i-1] {
+				i--
+				match--
+				n++
+			}
+			delta.addInsert(cur[literal:i])
+			delta.addCopy(match, n)
+			i += n
+			literal = i
+			if i+deltaBlockSize > len(cur) {
+				break
+			}
+			h = blockHash(cur[i : i+deltaBlockSize])
+			continue
+		}
+
+		if i+deltaBlockSize >= len(cur) {
+			break
+		}
+		h = (h-uint32(cur[i])*outWeight)*deltaHashBase + uint32(cur[i+deltaBlockSize])
+		i++
+	}
+	delta.addInsert(cur[literal:])
+	return delta
+}
+
+// validate checks that every op stays within a base of oldLen bytes and
+// that the ops produce exactly newLen bytes, without overflowing.
+func (d *COWDelta) validate() bool {
+	if d.oldLen < 0 || d.newLen < 0 {
+		return false
+	}
+	total := 0
+	for _, op := range d.ops {
+		switch op.kind {
+		case deltaCopy:
+			if op.offset < 0 || op.length < 0 || op.offset > d.oldLen || op.length > d.oldLen-op.offset {
+				return false
+			}
+		case deltaInsert:
+			if op.length != len(op.data) {
+				return false
+			}
+		default:
+			return false
+		}
+		if op.length > d.newLen-total {
+			return false
+		}
+		total += op.length
+	}
+	return total == d.newLen
+}
+
+// Patch applies a delta computed against b. A delta that copies the whole
+// base yields a clone sharing b's storage.
+func (b *COWBuffer) Patch(delta COWDelta) (COWBuffer, error) {
+	if delta.oldLen != len(b.data) {
+		return COWBuffer{}, ErrDeltaMismatch
+	}
+	if !delta.validate() {
+		return COWBuffer{}, ErrCorruptDelta
+	}
+	if len(delta.ops) == 1 && delta.ops[0].kind == deltaCopy &&
+		delta.ops[0].offset == 0 && delta.ops[0].length == len(b.data) {
+		return b.Clone(), nil
+	}
+
+	// validate bounds newLen by what the ops actually produce
+	data := make([]byte, 0, delta.newLen)
+	for _, op := range delta.ops {
+		if op.kind == deltaCopy {
+			data = append(data, b.data[op.offset:op.offset+op.length]...)
+		} else {
+			data = append(data, op.data...)
+		}
+	}
+
+	patched := NewCOWBuffer(data)
+	patched.alloc = b.alloc
+	patched.stats = b.stats
+	return patched, nil
+}
+
+// MarshalBinary encodes the delta as uvarint lengths followed by ops: a
+// tag byte, then offset and length for copies or length and bytes for
+// inserts.
+func (d COWDelta) MarshalBinary() ([]byte, error) {
+	out := binary.AppendUvarint(nil, uint64(d.oldLen))
+	out = binary.AppendUvarint(out, uint64(d.newLen))
+	for _, op := range d.ops {
+		out = append(out, op.kind)
+		if op.kind == deltaCopy {
+			out = binary.AppendUvarint(out, uint64(op.offset))
+		}
+		out = binary.AppendUvarint(out, uint64(op.length))
+		out = append(out, op.data...)
+	}
+	return out, nil
+}
+
+// UnmarshalBinary rejects deltas whose ops leave the base or do not add up
+// to the new length, so a decoded delta is safe to Patch.
+func (d *COWDelta) UnmarshalBinary(data []byte) error {
+	readUvarint := func() (int, bool) {
+		v, n := binary.Uvarint(data)
+		if n <= 0 || v > uint64(^uint(0)>>1) {
+			return 0, false
+		}
+		data = data[n:]
+		return int(v), true
+	}
+
+	var decoded COWDelta
+	var ok bool
+	if decoded.oldLen, ok = readUvarint(); !ok {
+		return ErrCorruptDelta
+	}
+	if decoded.newLen, ok = readUvarint(); !ok {
+		return ErrCorruptDelta
+	}
+	for len(data) > 0 {
+		op := deltaOp{kind: data[0]}
+		data = data[1:]
+		switch op.kind {
+		case deltaCopy:
+			if op.offset, ok = readUvarint(); !ok {
+				return ErrCorruptDelta
+			}
+			if op.length, ok = readUvarint(); !ok {
+				return ErrCorruptDelta
+			}
+		case deltaInsert:
+			if op.length, ok = readUvarint(); !ok || op.length > len(data) {
+				return ErrCorruptDelta
+			}
+			op.data = append([]byte(nil), data[:op.length]...)
+			data = data[op.length:]
+		default:
+			return ErrCorruptDelta
+		}
+		decoded.ops = append(decoded.ops, op)
+	}
+	if !decoded.validate() {
+		return ErrCorruptDelta
+	}
+	*d = decoded
+	return nil
+}
+
+func roundTrip(t *testing.T, old, cur []byte) COWDelta {
+	base := NewCOWBuffer(old)
+	defer base.Close()
+	newer := NewCOWBuffer(cur)
+	defer newer.Close()
+
+	delta := base.Diff(&newer)
+	encoded, err := delta.MarshalBinary()
+	assert.NoError(t, err)
+
+	var decoded COWDelta
+	assert.NoError(t, decoded.UnmarshalBinary(encoded))
+	assert.Equal(t, delta, decoded)
+
+	patched, err := base.Patch(decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cur), patched.String())
+	patched.Close()
+	return delta
+}
+
+func TestCOWBufferDiff(t *testing.T) {
+	document := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 50)
+
+	base := NewCOWBuffer(document)
+	defer base.Close()
+	edited := base.Clone()
+	defer edited.Close()
+	assert.True(t, edited.Update(100, 'Q'))
+	assert.True(t, edited.InsertString(1000, "inserted text"))
+
+	delta := base.Diff(&edited)
+	encoded, err := delta.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Less(t, len(encoded), 64)
+
+	patched, err := base.Patch(delta)
+	assert.NoError(t, err)
+	assert.Equal(t, edited.String(), patched.String())
+	patched.Close()
+
+	// an unchanged version shares the base storage
+	same := base.Clone()
+	delta = base.Diff(&same)
+	same.Close()
+	unchanged, err := base.Patch(delta)
+	assert.NoError(t, err)
+	assert.True(t, unsafe.SliceData(document) == unsafe.SliceData(unchanged.data))
+	unchanged.Close()
+
+	roundTrip(t, nil, nil)
+	roundTrip(t, []byte("short"), []byte("tiny"))
+	roundTrip(t, document, nil)
+	roundTrip(t, nil, document)
+	roundTrip(t, document, append(append([]byte("prefix"), document[500:]...), document[:300]...))
+}
+
+func TestCOWBufferDiffRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(42))
+	for iteration := 0; iteration < 50; iteration++ {
+		old := make([]byte, random.Intn(4096))
+		random.Read(old)
+
+		cur := append([]byte(nil), old...)
+		for edits := random.Intn(8); edits > 0 && len(cur) > 0; edits-- {
+			at := random.Intn(len(cur))
+			switch random.Intn(3) {
+			case 0:
+				cur[at] ^= 0xff
+			case 1:
+				cur = append(cur[:at], cur[at+random.Intn(len(cur)-at):]...)
+			case 2:
+				insert := make([]byte, random.Intn(64))
+				random.Read(insert)
+				cur = append(cur[:at], append(insert, cur[at:]...)...)
+			}
+		}
+		roundTrip(t, old, cur)
+	}
+}
+
+func TestCOWBufferPatchErrors(t *testing.T) {
+	base := NewCOWBuffer([]byte("0123456789abcdef0123456789abcdef"))
+	defer base.Close()
+	other := NewCOWBuffer([]byte("short"))
+	defer other.Close()
+
+	delta := base.Diff(&base)
+	_, err := other.Patch(delta)
+	assert.Equal(t, ErrDeltaMismatch, err)
+
+	delta.ops = append(delta.ops, deltaOp{kind: deltaCopy, offset: 30, length: 10})
+	_, err = base.Patch(delta)
+	assert.Equal(t, ErrCorruptDelta, err)
+
+	var decoded COWDelta
+	assert.Equal(t, ErrCorruptDelta, decoded.UnmarshalBinary(nil))
+	assert.Equal(t, ErrCorruptDelta, decoded.UnmarshalBinary([]byte{1, 1, deltaInsert, 5, 'a'}))
+	assert.Equal(t, ErrCorruptDelta, decoded.UnmarshalBinary([]byte{1, 1, 7}))
+	assert.Equal(t, ErrCorruptDelta, decoded.UnmarshalBinary([]byte{1, 1, deltaCopy, 0}))
+}
+
+func TestCOWBufferPatchHostile(t *testing.T) {
+	base := NewCOWBuffer([]byte("0123456789abcdef"))
+	defer base.Close()
+
+	// offset+length overflows and wraps around to a small value
+	overflow := COWDelta{oldLen: 16, newLen: 1, ops: []deltaOp{{kind: deltaCopy, offset: math.MaxInt, length: 1}}}
+	_, err := base.Patch(overflow)
+	assert.Equal(t, ErrCorruptDelta, err)
+	encoded, _ := overflow.MarshalBinary()
+	var decoded COWDelta
+	assert.Equal(t, ErrCorruptDelta, decoded.UnmarshalBinary(encoded))
+
+	// a huge new length must not be allocated up front
+	huge := COWDelta{oldLen: 16, newLen: math.MaxInt, ops: []deltaOp{{kind: deltaCopy, offset: 0, length: 16}}}
+	_, err = base.Patch(huge)
+	assert.Equal(t, ErrCorruptDelta, err)
+	encoded = binary.AppendUvarint([]byte{16}, math.MaxInt64)
+	assert.Equal(t, ErrCorruptDelta, decoded.UnmarshalBinary(encoded))
+
+	// ops that produce more or fewer bytes than announced
+	short := COWDelta{oldLen: 16, newLen: 8, ops: []deltaOp{{kind: deltaCopy, offset: 0, length: 4}}}
+	_, err = base.Patch(short)
+	assert.Equal(t, ErrCorruptDelta, err)
+	long := COWDelta{oldLen: 16, newLen: 2, ops: []deltaOp{{kind: deltaInsert, length: 3, data: []byte("abc")}}}
+	_, err = base.Patch(long)
+	assert.Equal(t, ErrCorruptDelta, err)
+
+	// a copy beyond the base that UnmarshalBinary used to accept
+	assert.Equal(t, ErrCorruptDelta, decoded.UnmarshalBinary([]byte{16, 4, deltaCopy, 14, 4}))
+	assert.NoError(t, decoded.UnmarshalBinary([]byte{16, 4, deltaCopy, 12, 4}))
+	patched, err := base.Patch(decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, "cdef", patched.String())
+	patched.Close()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// COWSlice is COWBuffer for arbitrary element types. The counter is atomic
+// so clones may be handed to and closed by other goroutines; a single clone
+// must still be used by one goroutine at a time.
+type COWSlice[T any] struct {
+	data []T
+	refs *atomic.Int64
+}
+
+func NewCOWSlice[T any](data []T) COWSlice[T] {
+	refs := &atomic.Int64{}
+	refs.Store(1)
+	return COWSlice[T]{data, refs}
+}
+
+func (s *COWSlice[T]) Clone() COWSlice[T] {
+	s.refs.Add(1)
+	return COWSlice[T]{s.data, s.refs}
+}
+
+func (s *COWSlice[T]) Close() {
+	if s.data != nil {
+		s.data = nil
+		s.refs.Add(-1)
+	}
+}
+
+func (s *COWSlice[T]) Update(index int, value T) bool {
+	if index < 0 || index >= len(s.data) || s.refs.Load() <= 0 {
+		return false
+	}
+	if s.refs.Load() > 1 {
+		newData := make([]T, len(s.data))
+		copy(newData, s.data)
+		s.refs.Add(-1)
+		s.data = newData
+		s.refs = &atomic.Int64{}
+		s.refs.Store(1)
+	}
+	s.data[index] = value
+
+	return true
+}
+
+func (s *COWSlice[T]) Get(index int) (T, bool) {
+	if index < 0 || index >= len(s.data) {
+		var zero T
+		return zero, false
+	}
+	return s.data[index], true
+}
+
+func (s *COWSlice[T]) Len() int {
+	return len(s.data)
+}
+
+// Range calls fn for each element in order until fn returns false.
+func (s *COWSlice[T]) Range(fn func(index int, value T) bool) {
+	for i, v := range s.data {
+		if !fn(i, v) {
+			return
+		}
+	}
+}
+
+type point struct {
+	x, y float64
+}
+
+func TestCOWSlice(t *testing.T) {
+	data := []point{{1, 2}, {3, 4}, {5, 6}}
+	slice := NewCOWSlice(data)
+	defer slice.Close()
+
+	snapshot := slice.Clone()
+	defer snapshot.Close()
+
+	assert.Equal(t, int64(2), slice.refs.Load())
+	assert.True(t, unsafe.SliceData(data) == unsafe.SliceData(snapshot.data))
+	assert.Equal(t, 3, snapshot.Len())
+
+	value, ok := snapshot.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, point{3, 4}, value)
+	_, ok = snapshot.Get(3)
+	assert.False(t, ok)
+	_, ok = snapshot.Get(-1)
+	assert.False(t, ok)
+
+	assert.True(t, slice.Update(0, point{0, 0}))
+	assert.False(t, slice.Update(3, point{}))
+	assert.True(t, unsafe.SliceData(data) != unsafe.SliceData(slice.data))
+	assert.Equal(t, int64(1), slice.refs.Load())
+	assert.Equal(t, int64(1), snapshot.refs.Load())
+
+	first, _ := snapshot.Get(0)
+	assert.Equal(t, point{1, 2}, first)
+	first, _ = slice.Get(0)
+	assert.Equal(t, point{0, 0}, first)
+
+	previous := unsafe.SliceData(snapshot.data)
+	assert.True(t, snapshot.Update(2, point{7, 8}))
+	assert.True(t, previous == unsafe.SliceData(snapshot.data))
+
+	var visited []point
+	snapshot.Range(func(i int, p point) bool {
+		visited = append(visited, p)
+		return i < 1
+	})
+	assert.Equal(t, []point{{1, 2}, {3, 4}}, visited)
+
+	closed := slice.Clone()
+	closed.Close()
+	closed.Close()
+	assert.Equal(t, int64(1), slice.refs.Load())
+	assert.Equal(t, 0, closed.Len())
+}
+
+func TestCOWSliceGoroutines(t *testing.T) {
+	const workers = 8
+
+	samples := make([]float64, 1024)
+	for i := range samples {
+		samples[i] = float64(i)
+	}
+	shared := NewCOWSlice(samples)
+	defer shared.Close()
+
+	clones := make([]COWSlice[float64], workers)
+	for w := range clones {
+		clones[w] = shared.Clone()
+	}
+
+	sums := make([]float64, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			clone := clones[w]
+			defer clone.Close()
+			if w%2 == 0 {
+				clone.Update(0, 1000)
+			}
+			clone.Range(func(_ int, v float64) bool {
+				sums[w] += v
+				return true
+			})
+		}(w)
+	}
+	wg.Wait()
+
+	base := float64(1023 * 1024 / 2)
+	for w, sum := range sums {
+		if w%2 == 0 {
+			assert.Equal(t, base+1000, sum)
+		} else {
+			assert.Equal(t, base, sum)
+		}
+	}
+	assert.Equal(t, int64(1), shared.refs.Load())
+	assert.Equal(t, float64(0), samples[0])
+}
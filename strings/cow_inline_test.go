@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const inlineCapacity = 32
+
+// InlineCOWBuffer keeps up to inlineCapacity bytes by value: such buffers
+// are cloned by copying and need no reference counter. Longer contents
+// fall back to a shared COWBuffer. Unlike NewCOWBuffer, short data is
+// copied, so the caller may reuse it.
+type InlineCOWBuffer struct {
+	inline [inlineCapacity]byte
+	length uint8
+	heap   bool
+	shared COWBuffer
+}
+
+func NewInlineCOWBuffer(data []byte) InlineCOWBuffer {
+	if len(data) > inlineCapacity {
+		return InlineCOWBuffer{heap: true, shared: NewCOWBuffer(data)}
+	}
+	var buffer InlineCOWBuffer
+	buffer.length = uint8(copy(buffer.inline[:], data))
+	return buffer
+}
+
+func (b *InlineCOWBuffer) Clone() InlineCOWBuffer {
+	if b.heap {
+		return InlineCOWBuffer{heap: true, shared: b.shared.Clone()}
+	}
+	return *b
+}
+
+func (b *InlineCOWBuffer) Close() {
+	if b.heap {
+		b.shared.Close()
+		return
+	}
+	b.length = 0
+}
+
+func (b *InlineCOWBuffer) Update(index int, value byte) bool {
+	if b.heap {
+		return b.shared.Update(index, value)
+	}
+	if index < 0 || index >= int(b.length) {
+		return false
+	}
+	b.inline[index] = value
+	return true
+}
+
+func (b *InlineCOWBuffer) Len() int {
+	if b.heap {
+		return len(b.shared.data)
+	}
+	return int(b.length)
+}
+
+// String copies inline contents: the array moves together with the value,
+// so a zero-copy string could not safely point into it.
+func (b *InlineCOWBuffer) String() string {
+	if b.heap {
+		return b.shared.String()
+	}
+	return string(b.inline[:b.length])
+}
+
+func TestInlineCOWBuffer(t *testing.T) {
+	data := []byte("short key")
+	buffer := NewInlineCOWBuffer(data)
+	defer buffer.Close()
+
+	assert.False(t, buffer.heap)
+	assert.Equal(t, 9, buffer.Len())
+	assert.Equal(t, "short key", buffer.String())
+
+	data[0] = 'S'
+	assert.Equal(t, "short key", buffer.String())
+
+	clone := buffer.Clone()
+	assert.True(t, clone.Update(0, 'S'))
+	assert.Equal(t, "Short key", clone.String())
+	assert.Equal(t, "short key", buffer.String())
+	assert.False(t, clone.Update(9, 'x'))
+	assert.False(t, clone.Update(-1, 'x'))
+
+	clone.Close()
+	assert.Equal(t, 0, clone.Len())
+	assert.False(t, clone.Update(0, 'x'))
+	assert.Equal(t, "short key", buffer.String())
+
+	exact := NewInlineCOWBuffer(make([]byte, inlineCapacity))
+	assert.False(t, exact.heap)
+	assert.Equal(t, inlineCapacity, exact.Len())
+
+	empty := NewInlineCOWBuffer(nil)
+	assert.Equal(t, "", empty.String())
+}
+
+func TestInlineCOWBufferFallback(t *testing.T) {
+	data := []byte("this payload does not fit into the inline array")
+	buffer := NewInlineCOWBuffer(data)
+	defer buffer.Close()
+
+	assert.True(t, buffer.heap)
+	assert.True(t, unsafe.SliceData(data) == unsafe.SliceData(buffer.shared.data))
+
+	clone := buffer.Clone()
+	assert.Equal(t, 2, *buffer.shared.refs)
+	assert.True(t, unsafe.SliceData(data) == unsafe.SliceData(clone.shared.data))
+
+	assert.True(t, clone.Update(0, 'T'))
+	assert.Equal(t, byte('T'), clone.String()[0])
+	assert.Equal(t, byte('t'), buffer.String()[0])
+	assert.Equal(t, len(data), clone.Len())
+
+	clone.Close()
+	assert.Equal(t, 1, *buffer.shared.refs)
+}
+
+func BenchmarkSmallBuffer(b *testing.B) {
+	data := []byte("user:1234567890")
+
+	b.Run("shared", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buffer := NewCOWBuffer(data)
+			clone := buffer.Clone()
+			clone.Update(0, 'U')
+			clone.Close()
+			buffer.Close()
+		}
+	})
+	b.Run("inline", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buffer := NewInlineCOWBuffer(data)
+			clone := buffer.Clone()
+			clone.Update(0, 'U')
+			clone.Close()
+			buffer.Close()
+		}
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	ErrNegativeOffset = errors.New("negative offset")
+	ErrOutOfRange     = errors.New("write out of buffer range")
+	ErrInvalidWhence  = errors.New("invalid whence")
+)
+
+// COWReader is a read cursor over a clone of a COWBuffer, so the source
+// buffer may be updated while the reader still sees the old contents.
+type COWReader struct {
+	buf COWBuffer
+	off int64
+}
+
+func (b *COWBuffer) NewReader() *COWReader {
+	return &COWReader{buf: b.Clone()}
+}
+
+func (r *COWReader) Len() int {
+	if r.off >= int64(len(r.buf.data)) {
+		return 0
+	}
+	return len(r.buf.data) - int(r.off)
+}
+
+func (r *COWReader) Size() int64 {
+	return int64(len(r.buf.data))
+}
+
+func (r *COWReader) Read(p []byte) (int, error) {
+	if r.off >= int64(len(r.buf.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.buf.data[r.off:])
+	r.off += int64(n)
+	return n, nil
+}
+
+func (r *COWReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+	if off >= int64(len(r.buf.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.buf.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *COWReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.off + offset
+	case io.SeekEnd:
+		abs = int64(len(r.buf.data)) + offset
+	default:
+		return 0, ErrInvalidWhence
+	}
+	if abs < 0 {
+		return 0, ErrNegativeOffset
+	}
+	r.off = abs
+	return abs, nil
+}
+
+func (r *COWReader) WriteTo(w io.Writer) (int64, error) {
+	if r.off >= int64(len(r.buf.data)) {
+		return 0, nil
+	}
+	rest := r.buf.data[r.off:]
+	n, err := w.Write(rest)
+	r.off += int64(n)
+	if err == nil && n != len(rest) {
+		err = io.ErrShortWrite
+	}
+	return int64(n), err
+}
+
+func (r *COWReader) Close() {
+	r.buf.Close()
+}
+
+// WriteAt never grows the buffer: writes that do not fit are rejected whole.
+func (b *COWBuffer) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+	if off+int64(len(p)) > int64(len(b.data)) || *b.refs <= 0 {
+		return 0, ErrOutOfRange
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b.detach()
+	return copy(b.data[off:], p), nil
+}
+
+var (
+	_ io.Reader   = (*COWReader)(nil)
+	_ io.ReaderAt = (*COWReader)(nil)
+	_ io.Seeker   = (*COWReader)(nil)
+	_ io.WriterTo = (*COWReader)(nil)
+	_ io.WriterAt = (*COWBuffer)(nil)
+)
+
+func TestCOWReader(t *testing.T) {
+	buffer := NewCOWBuffer([]byte("hello, world"))
+	defer buffer.Close()
+
+	reader := buffer.NewReader()
+	defer reader.Close()
+
+	assert.Equal(t, 2, *buffer.refs)
+	assert.True(t, unsafe.SliceData(buffer.data) == unsafe.SliceData(reader.buf.data))
+	assert.Equal(t, 12, reader.Len())
+	assert.Equal(t, int64(12), reader.Size())
+
+	head := make([]byte, 5)
+	n, err := reader.Read(head)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "hello", string(head))
+	assert.Equal(t, 7, reader.Len())
+
+	pos, err := reader.Seek(2, io.SeekCurrent)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), pos)
+
+	rest, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(rest))
+
+	n, err = reader.Read(head)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+
+	pos, err = reader.Seek(-5, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), pos)
+
+	_, err = reader.Seek(-1, io.SeekStart)
+	assert.Equal(t, ErrNegativeOffset, err)
+	_, err = reader.Seek(0, 42)
+	assert.Equal(t, ErrInvalidWhence, err)
+
+	at := make([]byte, 4)
+	n, err = reader.ReadAt(at, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "hell", string(at[:n]))
+	n, err = reader.ReadAt(at, 10)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "ld", string(at[:n]))
+	_, err = reader.ReadAt(at, -1)
+	assert.Equal(t, ErrNegativeOffset, err)
+
+	// position was not moved by ReadAt
+	line, err := bufio.NewReader(reader).ReadString('\n')
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "world", line)
+}
+
+func TestCOWReaderWriteTo(t *testing.T) {
+	payload := bytes.Repeat([]byte("abcdef"), 100)
+	buffer := NewCOWBuffer(payload)
+	defer buffer.Close()
+
+	hash := sha256.New()
+	reader := buffer.NewReader()
+	written, err := io.Copy(hash, reader)
+	reader.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(payload)), written)
+	assert.Equal(t, sha256.Sum256(payload), [sha256.Size]byte(hash.Sum(nil)))
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	reader = buffer.NewReader()
+	_, err = reader.WriteTo(zw)
+	reader.Close()
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+
+	zr, err := gzip.NewReader(&compressed)
+	assert.NoError(t, err)
+	plain, err := io.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, plain)
+}
+
+func TestCOWBufferWriteAt(t *testing.T) {
+	data := []byte("abcdef")
+	buffer := NewCOWBuffer(data)
+	defer buffer.Close()
+
+	reader := buffer.NewReader()
+	defer reader.Close()
+
+	n, err := buffer.WriteAt([]byte("XY"), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "abXYef", buffer.String())
+
+	// writer detached, reader keeps the original storage
+	assert.True(t, unsafe.SliceData(buffer.data) != unsafe.SliceData(reader.buf.data))
+	assert.True(t, unsafe.SliceData(data) == unsafe.SliceData(reader.buf.data))
+	snapshot, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdef", string(snapshot))
+
+	// sole owner writes in place
+	previous := unsafe.SliceData(buffer.data)
+	_, err = buffer.WriteAt([]byte("Z"), 0)
+	assert.NoError(t, err)
+	assert.True(t, previous == unsafe.SliceData(buffer.data))
+	assert.Equal(t, "ZbXYef", buffer.String())
+
+	_, err = buffer.WriteAt([]byte("long"), 4)
+	assert.Equal(t, ErrOutOfRange, err)
+	_, err = buffer.WriteAt([]byte("a"), -1)
+	assert.Equal(t, ErrNegativeOffset, err)
+	assert.Equal(t, "ZbXYef", buffer.String())
+}
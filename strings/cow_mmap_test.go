@@ -0,0 +1,219 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// NewCOWBufferFromFile maps the file read-only. Clones and slices share the
+// mapping, and the file stays open until the last of them is closed. The
+// first Update of a buffer maps its range again privately and writable, so
+// the kernel copies only the pages that are written, even for the last
+// owner; the shared mapping is unmapped once no buffer uses it. Copies of an
+// edited buffer are made on the heap as for any other buffer.
+//
+// Strings returned by String point into the mapping and must not outlive
+// Close: reading one after the last Close faults instead of returning
+// stale data. Errors from unmapping on the last Close are ignored.
+func NewCOWBufferFromFile(path string) (COWBuffer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return COWBuffer{}, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return COWBuffer{}, err
+	}
+	if info.Size() == 0 {
+		file.Close()
+		return NewCOWBuffer([]byte{}), nil
+	}
+
+	mapping, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		file.Close()
+		return COWBuffer{}, err
+	}
+	unmap := func() error {
+		err := syscall.Munmap(mapping)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	private := func(data []byte) ([]byte, func() error, error) {
+		offset := int(uintptr(unsafe.Pointer(unsafe.SliceData(data))) - uintptr(unsafe.Pointer(unsafe.SliceData(mapping))))
+		start := offset &^ (os.Getpagesize() - 1)
+		region, err := syscall.Mmap(int(file.Fd()), int64(start), offset-start+len(data),
+			syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+		if err != nil {
+			return nil, nil, err
+		}
+		from, to := offset-start, offset-start+len(data)
+		return region[from:to:to], func() error { return syscall.Munmap(region) }, nil
+	}
+
+	var refs int = 1
+	return COWBuffer{data: mapping, refs: &refs, free: unmap, readOnly: true, private: private}, nil
+}
+
+// mappings returns the permissions of every mapping of path, e.g. "r--s".
+func mappings(t *testing.T, path string) []string {
+	maps, err := os.ReadFile("/proc/self/maps")
+	if err != nil {
+		t.Skip("/proc/self/maps is not available")
+	}
+	var perms []string
+	for _, line := range strings.Split(string(maps), "\n") {
+		if fields := strings.Fields(line); len(fields) == 6 && fields[5] == path {
+			perms = append(perms, fields[1])
+		}
+	}
+	return perms
+}
+
+// anonymousKB returns how much of the private mapping of path has been
+// copied by the kernel.
+func anonymousKB(t *testing.T, path string) int {
+	smaps, err := os.ReadFile("/proc/self/smaps")
+	if err != nil {
+		t.Skip("/proc/self/smaps is not available")
+	}
+	inside := false
+	for _, line := range strings.Split(string(smaps), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 6 && strings.Contains(fields[0], "-") {
+			inside = fields[5] == path && fields[1] == "rw-p"
+		} else if inside && len(fields) == 3 && fields[0] == "Anonymous:" {
+			kb, _ := strconv.Atoi(fields[1])
+			return kb
+		}
+	}
+	return -1
+}
+
+func TestCOWBufferFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "huge.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("header;body;footer"), 0o644))
+
+	buffer, err := NewCOWBufferFromFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "header;body;footer", buffer.String())
+
+	clone := buffer.Clone()
+	body, ok := buffer.Slice(7, 11)
+	assert.True(t, ok)
+	assert.Equal(t, 3, *buffer.refs)
+	assert.True(t, unsafe.SliceData(buffer.data) == unsafe.SliceData(clone.data))
+	assert.True(t, &buffer.data[7] == unsafe.SliceData(body.data))
+
+	// writes go to private mappings, never to the shared one
+	assert.True(t, body.Update(0, 'B'))
+	assert.Equal(t, "Body", body.String())
+	assert.False(t, body.readOnly)
+	assert.Equal(t, 2, *buffer.refs)
+
+	assert.True(t, clone.Update(0, 'H'))
+	assert.Equal(t, "Header;body;footer", clone.String())
+	assert.Equal(t, 1, *buffer.refs)
+
+	previous := unsafe.SliceData(clone.data)
+	assert.True(t, clone.Update(1, 'E'))
+	assert.True(t, previous == unsafe.SliceData(clone.data))
+
+	onDisk, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "header;body;footer", string(onDisk))
+	assert.Equal(t, "header;body;footer", buffer.String())
+
+	assert.ElementsMatch(t, []string{"r--s", "rw-p", "rw-p"}, mappings(t, path))
+	buffer.Close()
+	assert.Equal(t, 0, *buffer.refs)
+	assert.Equal(t, []string{"rw-p", "rw-p"}, mappings(t, path))
+
+	assert.Equal(t, "HEader;body;footer", clone.String())
+	assert.Equal(t, "Body", body.String())
+	clone.Close()
+	body.Close()
+	assert.Empty(t, mappings(t, path))
+}
+
+func TestCOWBufferFromFileLastUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "single.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("abc"), 0o644))
+
+	buffer, err := NewCOWBufferFromFile(path)
+	assert.NoError(t, err)
+
+	// sole owner leaving the shared mapping releases it right away
+	assert.True(t, buffer.Update(2, 'C'))
+	assert.Equal(t, "abC", buffer.String())
+	assert.Equal(t, []string{"rw-p"}, mappings(t, path))
+	buffer.Close()
+	assert.Empty(t, mappings(t, path))
+}
+
+func TestCOWBufferFromFilePages(t *testing.T) {
+	page := os.Getpagesize()
+	path := filepath.Join(t.TempDir(), "pages.bin")
+	assert.NoError(t, os.WriteFile(path, bytes.Repeat([]byte{'a'}, 4*page), 0o644))
+
+	buffer, err := NewCOWBufferFromFile(path)
+	assert.NoError(t, err)
+	defer buffer.Close()
+
+	// one written byte copies one page, not the file
+	edit := buffer.Clone()
+	defer edit.Close()
+	assert.True(t, edit.Update(2*page+10, 'b'))
+	assert.Equal(t, page/1024, anonymousKB(t, path))
+	assert.Equal(t, byte('b'), edit.data[2*page+10])
+	assert.Equal(t, byte('a'), buffer.data[2*page+10])
+
+	// a view that does not start on a page boundary
+	view, ok := buffer.Slice(page+100, 3*page)
+	assert.True(t, ok)
+	defer view.Close()
+	assert.True(t, view.Update(0, 'c'))
+	assert.Equal(t, byte('c'), view.data[0])
+	assert.Equal(t, 2*page-100, len(view.data))
+	assert.Equal(t, byte('a'), buffer.data[page+100])
+
+	// copies of an edited buffer are ordinary heap copies
+	second := edit.Clone()
+	defer second.Close()
+	assert.True(t, second.Update(0, 'z'))
+	assert.Nil(t, second.free)
+	assert.Equal(t, byte('a'), edit.data[0])
+
+	onDisk, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{'a'}, 4*page), onDisk)
+}
+
+func TestCOWBufferFromFileErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := NewCOWBufferFromFile(filepath.Join(dir, "missing"))
+	assert.True(t, os.IsNotExist(err))
+
+	path := filepath.Join(dir, "empty")
+	assert.NoError(t, os.WriteFile(path, nil, 0o644))
+	buffer, err := NewCOWBufferFromFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "", buffer.String())
+	assert.Nil(t, buffer.free)
+	buffer.Close()
+}
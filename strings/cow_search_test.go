@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func unsafeBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+func (b *COWBuffer) Index(substr string) int {
+	return bytes.Index(b.data, unsafeBytes(substr))
+}
+
+func (b *COWBuffer) LastIndex(substr string) int {
+	return bytes.LastIndex(b.data, unsafeBytes(substr))
+}
+
+func (b *COWBuffer) Contains(substr string) bool {
+	return b.Index(substr) >= 0
+}
+
+// Split returns views sharing the buffer's storage; the caller closes each
+// of them. An empty separator splits after each UTF-8 sequence.
+func (b *COWBuffer) Split(sep string) []COWBuffer {
+	if b.data == nil {
+		return nil
+	}
+	if len(sep) == 0 {
+		runes := b.runeIndex()
+		parts := make([]COWBuffer, 0, len(runes))
+		for i, from := range runes {
+			to := len(b.data)
+			if i+1 < len(runes) {
+				to = runes[i+1]
+			}
+			part, _ := b.Slice(from, to)
+			parts = append(parts, part)
+		}
+		return parts
+	}
+
+	parts := make([]COWBuffer, 0, bytes.Count(b.data, unsafeBytes(sep))+1)
+	from := 0
+	for {
+		i := bytes.Index(b.data[from:], unsafeBytes(sep))
+		if i < 0 {
+			break
+		}
+		part, _ := b.Slice(from, from+i)
+		parts = append(parts, part)
+		from += i + len(sep)
+	}
+	part, _ := b.Slice(from, len(b.data))
+	return append(parts, part)
+}
+
+// matches returns the offsets where old occurs, with the semantics of
+// bytes.ReplaceAll: an empty old matches at the start and after each UTF-8
+// sequence.
+func (b *COWBuffer) matches(old string) []int {
+	if len(old) == 0 {
+		return append(append([]int(nil), b.runeIndex()...), len(b.data))
+	}
+	var offsets []int
+	for from := 0; ; {
+		i := bytes.Index(b.data[from:], unsafeBytes(old))
+		if i < 0 {
+			return offsets
+		}
+		offsets = append(offsets, from+i)
+		from += i + len(old)
+	}
+}
+
+// ReplaceAll returns the result as segments in order: views of the
+// unchanged runs sharing the buffer's storage, and clones of a single
+// block holding new for every replacement. Empty segments are left out and
+// the caller closes each one. Without a match it returns one clone.
+func (b *COWBuffer) ReplaceAll(old, new string) []COWBuffer {
+	if b.data == nil {
+		return nil
+	}
+	offsets := b.matches(old)
+	if old == new || len(offsets) == 0 {
+		return []COWBuffer{b.Clone()}
+	}
+
+	var replacement COWBuffer
+	if len(new) > 0 {
+		replacement = NewCOWBuffer([]byte(new))
+		replacement.alloc = b.alloc
+		replacement.stats = b.stats
+	}
+	segments := make([]COWBuffer, 0, 2*len(offsets)+1)
+	from := 0
+	for i, offset := range offsets {
+		if offset > from {
+			view, _ := b.Slice(from, offset)
+			segments = append(segments, view)
+		}
+		if len(new) > 0 {
+			if i == 0 {
+				segments = append(segments, replacement)
+			} else {
+				segments = append(segments, replacement.Clone())
+			}
+		}
+		from = offset + len(old)
+	}
+	if from < len(b.data) {
+		view, _ := b.Slice(from, len(b.data))
+		segments = append(segments, view)
+	}
+	return segments
+}
+
+func TestCOWBufferSearch(t *testing.T) {
+	buffer := NewCOWBuffer([]byte("a=1;b=2;c=3"))
+	defer buffer.Close()
+
+	assert.Equal(t, 1, buffer.Index("="))
+	assert.Equal(t, 9, buffer.LastIndex("="))
+	assert.Equal(t, 4, buffer.Index("b=2"))
+	assert.Equal(t, -1, buffer.Index("d"))
+	assert.Equal(t, -1, buffer.LastIndex("d"))
+	assert.True(t, buffer.Contains(";c"))
+	assert.False(t, buffer.Contains("=4"))
+	assert.True(t, buffer.Contains(""))
+}
+
+func TestCOWBufferSplit(t *testing.T) {
+	data := []byte("a=1;b=2;;c=3")
+	buffer := NewCOWBuffer(data)
+	defer buffer.Close()
+
+	parts := buffer.Split(";")
+	assert.Equal(t, 4, len(parts))
+	assert.Equal(t, 5, *buffer.refs)
+
+	var values []string
+	for _, part := range parts {
+		values = append(values, part.String())
+	}
+	assert.Equal(t, []string{"a=1", "b=2", "", "c=3"}, values)
+	assert.True(t, &data[4] == unsafe.SliceData(parts[1].data))
+	assert.True(t, &data[9] == unsafe.SliceData(parts[3].data))
+
+	assert.True(t, parts[1].Update(2, '9'))
+	assert.Equal(t, "b=9", parts[1].String())
+	assert.Equal(t, "a=1;b=2;;c=3", buffer.String())
+
+	for i := range parts {
+		parts[i].Close()
+	}
+	assert.Equal(t, 1, *buffer.refs)
+
+	whole := buffer.Split("|")
+	assert.Equal(t, 1, len(whole))
+	assert.Equal(t, "a=1;b=2;;c=3", whole[0].String())
+	whole[0].Close()
+
+	edges := buffer.Split("a=1")
+	assert.Equal(t, 2, len(edges))
+	assert.Equal(t, "", edges[0].String())
+	assert.Equal(t, ";b=2;;c=3", edges[1].String())
+	edges[0].Close()
+	edges[1].Close()
+
+	text := NewCOWBuffer([]byte("жи"))
+	runes := text.Split("")
+	assert.Equal(t, 2, len(runes))
+	assert.Equal(t, "ж", runes[0].String())
+	assert.Equal(t, "и", runes[1].String())
+	runes[0].Close()
+	runes[1].Close()
+	text.Close()
+
+	closed := buffer.Clone()
+	closed.Close()
+	assert.Nil(t, closed.Split(";"))
+}
+
+func joinSegments(segments []COWBuffer) string {
+	var joined []byte
+	for i := range segments {
+		joined = append(joined, segments[i].data...)
+	}
+	return string(joined)
+}
+
+func closeSegments(segments []COWBuffer) {
+	for i := range segments {
+		segments[i].Close()
+	}
+}
+
+func TestCOWBufferReplaceAll(t *testing.T) {
+	data := []byte("one two one")
+	buffer := NewCOWBuffer(data)
+	defer buffer.Close()
+
+	unchanged := buffer.ReplaceAll("three", "3")
+	assert.Equal(t, 1, len(unchanged))
+	assert.True(t, unsafe.SliceData(data) == unsafe.SliceData(unchanged[0].data))
+	assert.Equal(t, 2, *buffer.refs)
+	closeSegments(unchanged)
+
+	same := buffer.ReplaceAll("one", "one")
+	assert.True(t, unsafe.SliceData(data) == unsafe.SliceData(same[0].data))
+	closeSegments(same)
+
+	// " two " is a view, both "1"s share one new block
+	replaced := buffer.ReplaceAll("one", "1")
+	assert.Equal(t, 3, len(replaced))
+	assert.Equal(t, "1 two 1", joinSegments(replaced))
+	assert.True(t, &data[3] == unsafe.SliceData(replaced[1].data))
+	assert.True(t, unsafe.SliceData(replaced[0].data) == unsafe.SliceData(replaced[2].data))
+	assert.Equal(t, 2, *buffer.refs)
+	assert.Equal(t, 2, *replaced[0].refs)
+
+	// writing to a segment copies it and leaves the others alone
+	assert.True(t, replaced[2].Update(0, '2'))
+	assert.Equal(t, "1 two 2", joinSegments(replaced))
+	assert.Equal(t, "one two one", buffer.String())
+	closeSegments(replaced)
+	assert.Equal(t, 1, *buffer.refs)
+
+	removed := buffer.ReplaceAll("one", "")
+	assert.Equal(t, 1, len(removed))
+	assert.Equal(t, " two ", joinSegments(removed))
+	closeSegments(removed)
+
+	text := NewCOWBuffer([]byte("жи"))
+	defer text.Close()
+	around := text.ReplaceAll("", "-")
+	assert.Equal(t, "-ж-и-", joinSegments(around))
+	assert.Equal(t, string(bytes.ReplaceAll([]byte("жи"), nil, []byte("-"))), joinSegments(around))
+	closeSegments(around)
+
+	stats := NewCOWStats(nil)
+	buffer.Instrument(stats)
+	instrumented := buffer.ReplaceAll(" ", "_")
+	assert.Equal(t, "one_two_one", joinSegments(instrumented))
+	// three views and one clone of the replacement
+	assert.Equal(t, int64(4), stats.Snapshot().Clones)
+	closeSegments(instrumented)
+
+	closed := buffer.Clone()
+	closed.Close()
+	assert.Nil(t, closed.ReplaceAll("one", "1"))
+}
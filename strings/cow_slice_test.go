@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Slice returns a view of data[from:to] sharing the parent's storage and
+// reference counter, so the view is copied only when one side is updated.
+// A buffer without data, e.g. a closed one, has no views: Close could not
+// release their reference.
+func (b *COWBuffer) Slice(from, to int) (COWBuffer, bool) {
+	if b.data == nil || from < 0 || to < from || to > len(b.data) || *b.refs <= 0 {
+		return COWBuffer{}, false
+	}
+	*b.refs++
+	b.stats.recordClone(*b.refs)
+	view := *b
+	view.data = b.data[from:to:to]
+	view.runes = nil
+	return view, true
+}
+
+func TestCOWBufferSlice(t *testing.T) {
+	data := []byte("key=value;")
+	buffer := NewCOWBuffer(data)
+	defer buffer.Close()
+
+	key, ok := buffer.Slice(0, 3)
+	assert.True(t, ok)
+	defer key.Close()
+	value, ok := buffer.Slice(4, 9)
+	assert.True(t, ok)
+	defer value.Close()
+
+	assert.Equal(t, 3, *buffer.refs)
+	assert.Equal(t, "key", key.String())
+	assert.Equal(t, "value", value.String())
+	assert.True(t, unsafe.SliceData(data) == unsafe.SliceData(key.data))
+	assert.True(t, &data[4] == unsafe.SliceData(value.data))
+	assert.Equal(t, 5, cap(value.data))
+
+	assert.True(t, value.Update(0, 'V'))
+	assert.Equal(t, "Value", value.String())
+	assert.Equal(t, 1, *value.refs)
+	assert.Equal(t, 2, *buffer.refs)
+	assert.True(t, &data[4] != unsafe.SliceData(value.data))
+	assert.Equal(t, "key=value;", buffer.String())
+
+	assert.True(t, buffer.Update(0, 'K'))
+	assert.Equal(t, "Key=value;", buffer.String())
+	assert.Equal(t, "key", key.String())
+	assert.True(t, unsafe.SliceData(data) == unsafe.SliceData(key.data))
+
+	// the view is now the last owner of the original storage
+	assert.Equal(t, 1, *key.refs)
+	previous := unsafe.SliceData(key.data)
+	assert.True(t, key.Update(2, 'Y'))
+	assert.True(t, previous == unsafe.SliceData(key.data))
+	assert.Equal(t, "keY", key.String())
+
+	nested, ok := value.Slice(1, 3)
+	assert.True(t, ok)
+	assert.Equal(t, "al", nested.String())
+	assert.Equal(t, 2, *value.refs)
+	nested.Close()
+	assert.Equal(t, 1, *value.refs)
+
+	empty, ok := buffer.Slice(10, 10)
+	assert.True(t, ok)
+	assert.Equal(t, "", empty.String())
+	empty.Close()
+
+	_, ok = buffer.Slice(-1, 2)
+	assert.False(t, ok)
+	_, ok = buffer.Slice(3, 2)
+	assert.False(t, ok)
+	_, ok = buffer.Slice(0, 11)
+	assert.False(t, ok)
+}
+
+func TestCOWBufferSliceWithoutData(t *testing.T) {
+	empty := NewCOWBuffer(nil)
+	_, ok := empty.Slice(0, 0)
+	assert.False(t, ok)
+	assert.Equal(t, 1, *empty.refs)
+	empty.Close()
+
+	buffer := NewCOWBuffer([]byte("abc"))
+	defer buffer.Close()
+	closed := buffer.Clone()
+	closed.Close()
+	_, ok = closed.Slice(0, 0)
+	assert.False(t, ok)
+	assert.Equal(t, 1, *buffer.refs)
+}
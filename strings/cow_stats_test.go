@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type COWEventKind int
+
+const (
+	COWClone COWEventKind = iota
+	COWCopy
+)
+
+// COWEvent describes a single clone or copy. Sharers is the number of
+// references to the storage block right after a clone, or right before a
+// copy detaches the writer from it.
+type COWEvent struct {
+	Kind    COWEventKind
+	Bytes   int
+	Sharers int
+}
+
+type COWStatsSnapshot struct {
+	Clones      int64
+	Copies      int64
+	BytesCopied int64
+}
+
+// COWStats counts clones and copies of every buffer it is attached to,
+// including clones made after attaching. A nil *COWStats records nothing.
+type COWStats struct {
+	clones      atomic.Int64
+	copies      atomic.Int64
+	bytesCopied atomic.Int64
+	hook        func(COWEvent)
+}
+
+func NewCOWStats(hook func(COWEvent)) *COWStats {
+	return &COWStats{hook: hook}
+}
+
+func (b *COWBuffer) Instrument(stats *COWStats) {
+	b.stats = stats
+}
+
+// Sharers returns how many buffers reference the same storage block.
+func (b *COWBuffer) Sharers() int {
+	if b.data == nil {
+		return 0
+	}
+	return *b.refs
+}
+
+func (s *COWStats) recordClone(sharers int) {
+	if s == nil {
+		return
+	}
+	s.clones.Add(1)
+	if s.hook != nil {
+		s.hook(COWEvent{Kind: COWClone, Sharers: sharers})
+	}
+}
+
+func (s *COWStats) recordCopy(bytes, sharers int) {
+	if s == nil {
+		return
+	}
+	s.copies.Add(1)
+	s.bytesCopied.Add(int64(bytes))
+	if s.hook != nil {
+		s.hook(COWEvent{Kind: COWCopy, Bytes: bytes, Sharers: sharers})
+	}
+}
+
+func (s *COWStats) Snapshot() COWStatsSnapshot {
+	if s == nil {
+		return COWStatsSnapshot{}
+	}
+	return COWStatsSnapshot{
+		Clones:      s.clones.Load(),
+		Copies:      s.copies.Load(),
+		BytesCopied: s.bytesCopied.Load(),
+	}
+}
+
+// Publish exports the counters as an expvar. Like expvar.Publish it panics
+// if the name is already taken.
+func (s *COWStats) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return s.Snapshot()
+	}))
+}
+
+func TestCOWStats(t *testing.T) {
+	var events []COWEvent
+	stats := NewCOWStats(func(event COWEvent) {
+		events = append(events, event)
+	})
+
+	buffer := NewCOWBuffer([]byte("abcdef"))
+	defer buffer.Close()
+	buffer.Instrument(stats)
+	assert.Equal(t, 1, buffer.Sharers())
+
+	clone := buffer.Clone()
+	view, _ := buffer.Slice(0, 2)
+	assert.Equal(t, 3, buffer.Sharers())
+	assert.Equal(t, 3, view.Sharers())
+
+	assert.True(t, clone.Update(0, 'A'))
+	assert.True(t, clone.Update(1, 'B'))
+	assert.True(t, view.Update(0, 'X'))
+	assert.True(t, buffer.Update(0, 'Y'))
+
+	assert.Equal(t, COWStatsSnapshot{Clones: 2, Copies: 2, BytesCopied: 8}, stats.Snapshot())
+	assert.Equal(t, []COWEvent{
+		{Kind: COWClone, Sharers: 2},
+		{Kind: COWClone, Sharers: 3},
+		{Kind: COWCopy, Bytes: 6, Sharers: 3},
+		{Kind: COWCopy, Bytes: 2, Sharers: 2},
+	}, events)
+
+	// clones inherit the instrumentation
+	second := clone.Clone()
+	assert.True(t, second.Update(0, 'Z'))
+	assert.Equal(t, COWStatsSnapshot{Clones: 3, Copies: 3, BytesCopied: 14}, stats.Snapshot())
+
+	second.Close()
+	view.Close()
+	clone.Close()
+	assert.Equal(t, 0, clone.Sharers())
+	assert.Equal(t, 1, buffer.Sharers())
+}
+
+func TestCOWStatsUninstrumented(t *testing.T) {
+	buffer := NewCOWBuffer([]byte("abc"))
+	clone := buffer.Clone()
+	assert.True(t, clone.Update(0, 'A'))
+	clone.Close()
+	buffer.Close()
+
+	stats := NewCOWStats(nil)
+	buffer = NewCOWBuffer([]byte("abc"))
+	buffer.Instrument(stats)
+	clone = buffer.Clone()
+	assert.True(t, clone.Update(0, 'A'))
+	assert.Equal(t, COWStatsSnapshot{Clones: 1, Copies: 1, BytesCopied: 3}, stats.Snapshot())
+
+	var none *COWStats
+	assert.Equal(t, COWStatsSnapshot{}, none.Snapshot())
+}
+
+// publishRuns keeps expvar names unique when tests run with -count.
+var publishRuns atomic.Int64
+
+func TestCOWStatsPublish(t *testing.T) {
+	name := fmt.Sprintf("%s_%d", t.Name(), publishRuns.Add(1))
+	stats := NewCOWStats(nil)
+	stats.Publish(name)
+
+	buffer := NewCOWBuffer([]byte("abcd"))
+	defer buffer.Close()
+	buffer.Instrument(stats)
+	clone := buffer.Clone()
+	defer clone.Close()
+	clone.Update(0, 'A')
+
+	var exported COWStatsSnapshot
+	assert.NoError(t, json.Unmarshal([]byte(expvar.Get(name).String()), &exported))
+	assert.Equal(t, COWStatsSnapshot{Clones: 1, Copies: 1, BytesCopied: 4}, exported)
+}
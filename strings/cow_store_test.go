@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"hash/maphash"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type storeEntry struct {
+	data []byte
+	refs *int
+}
+
+// COWStore deduplicates buffers by content. Every buffer it returns shares
+// the stored block, which is read-only: Update always copies, and the block
+// is evicted when its last buffer is closed. Like COWBuffer, the store is
+// not safe for concurrent use.
+type COWStore struct {
+	seed    maphash.Seed
+	buckets map[uint64][]*storeEntry
+	size    int
+}
+
+func NewCOWStore() *COWStore {
+	return &COWStore{
+		seed:    maphash.MakeSeed(),
+		buckets: make(map[uint64][]*storeEntry),
+	}
+}
+
+// Put returns a buffer sharing the stored block equal to data, storing a
+// private copy of data when no such block is held yet.
+func (s *COWStore) Put(data []byte) COWBuffer {
+	h := maphash.Bytes(s.seed, data)
+	for _, entry := range s.buckets[h] {
+		if bytes.Equal(entry.data, data) {
+			*entry.refs++
+			return s.buffer(h, entry)
+		}
+	}
+
+	var refs int = 1
+	entry := &storeEntry{data: append(make([]byte, 0, len(data)), data...), refs: &refs}
+	s.buckets[h] = append(s.buckets[h], entry)
+	s.size += len(data)
+	return s.buffer(h, entry)
+}
+
+func (s *COWStore) PutBuffer(b *COWBuffer) COWBuffer {
+	return s.Put(b.data)
+}
+
+func (s *COWStore) buffer(h uint64, entry *storeEntry) COWBuffer {
+	free := func() error {
+		s.evict(h, entry)
+		return nil
+	}
+	return COWBuffer{data: entry.data, refs: entry.refs, free: free, readOnly: true}
+}
+
+func (s *COWStore) evict(h uint64, entry *storeEntry) {
+	bucket := s.buckets[h]
+	for i, e := range bucket {
+		if e == entry {
+			bucket[i] = bucket[len(bucket)-1]
+			bucket = bucket[:len(bucket)-1]
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(s.buckets, h)
+	} else {
+		s.buckets[h] = bucket
+	}
+	s.size -= len(entry.data)
+}
+
+// Len returns the number of distinct contents held.
+func (s *COWStore) Len() int {
+	n := 0
+	for _, bucket := range s.buckets {
+		n += len(bucket)
+	}
+	return n
+}
+
+// Size returns the number of content bytes held.
+func (s *COWStore) Size() int {
+	return s.size
+}
+
+func TestCOWStore(t *testing.T) {
+	store := NewCOWStore()
+
+	payload := []byte("repeated payload")
+	first := store.Put(payload)
+	payload[0] = 'R'
+	assert.Equal(t, "repeated payload", first.String())
+
+	second := store.Put([]byte("repeated payload"))
+	assert.True(t, unsafe.SliceData(first.data) == unsafe.SliceData(second.data))
+	assert.Equal(t, 2, *first.refs)
+
+	source := NewCOWBuffer([]byte("repeated payload"))
+	third := store.PutBuffer(&source)
+	source.Close()
+	assert.True(t, unsafe.SliceData(first.data) == unsafe.SliceData(third.data))
+
+	clone := third.Clone()
+	assert.Equal(t, 4, *first.refs)
+
+	other := store.Put([]byte("other"))
+	assert.Equal(t, 2, store.Len())
+	assert.Equal(t, len("repeated payload")+len("other"), store.Size())
+
+	// the stored block is never written, even by its last holder
+	other.Update(0, 'O')
+	assert.Equal(t, "Other", other.String())
+	assert.Equal(t, 1, store.Len())
+	other.Close()
+
+	assert.True(t, clone.Update(0, 'R'))
+	assert.Equal(t, "Repeated payload", clone.String())
+	assert.Equal(t, "repeated payload", first.String())
+	assert.Equal(t, 3, *first.refs)
+	clone.Close()
+
+	first.Close()
+	second.Close()
+	assert.Equal(t, 1, store.Len())
+	third.Close()
+	assert.Equal(t, 0, store.Len())
+	assert.Equal(t, 0, store.Size())
+
+	again := store.Put([]byte("repeated payload"))
+	assert.Equal(t, 1, *again.refs)
+	assert.Equal(t, 1, store.Len())
+	again.Close()
+}
+
+func TestCOWStoreCollisions(t *testing.T) {
+	store := NewCOWStore()
+
+	// force every content into one bucket
+	entries := []string{"a", "b", "c"}
+	var buffers []COWBuffer
+	for _, content := range entries {
+		var refs int = 1
+		entry := &storeEntry{data: []byte(content), refs: &refs}
+		store.buckets[0] = append(store.buckets[0], entry)
+		store.size += len(content)
+		buffers = append(buffers, store.buffer(0, entry))
+	}
+	assert.Equal(t, 3, store.Len())
+
+	buffers[1].Close()
+	assert.Equal(t, 2, len(store.buckets[0]))
+	buffers[0].Close()
+	buffers[2].Close()
+	assert.Equal(t, 0, store.Len())
+	_, ok := store.buckets[0]
+	assert.False(t, ok)
+}
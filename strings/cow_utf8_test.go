@@ -0,0 +1,187 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Invalid bytes count as one rune each, as in utf8.RuneCount.
+func (b *COWBuffer) runeIndex() []int {
+	if b.runes == nil {
+		runes := make([]int, 0, utf8.RuneCount(b.data))
+		for offset := 0; offset < len(b.data); {
+			runes = append(runes, offset)
+			_, size := utf8.DecodeRune(b.data[offset:])
+			offset += size
+		}
+		b.runes = runes
+	}
+	return b.runes
+}
+
+func (b *COWBuffer) Valid() bool {
+	return utf8.Valid(b.data)
+}
+
+func (b *COWBuffer) RuneCount() int {
+	return len(b.runeIndex())
+}
+
+func (b *COWBuffer) RuneAt(index int) (rune, bool) {
+	runes := b.runeIndex()
+	if index < 0 || index >= len(runes) {
+		return utf8.RuneError, false
+	}
+	r, _ := utf8.DecodeRune(b.data[runes[index]:])
+	return r, true
+}
+
+// ReplaceRune writes in place when the encoded length is unchanged and
+// rebuilds the storage otherwise.
+func (b *COWBuffer) ReplaceRune(index int, r rune) bool {
+	runes := b.runeIndex()
+	if index < 0 || index >= len(runes) || *b.refs <= 0 {
+		return false
+	}
+	offset := runes[index]
+	_, oldSize := utf8.DecodeRune(b.data[offset:])
+
+	var encoded [utf8.UTFMax]byte
+	newSize := utf8.EncodeRune(encoded[:], r)
+	if newSize == oldSize {
+		b.detach()
+		copy(b.data[offset:], encoded[:newSize])
+		return true
+	}
+	b.splice(offset, offset+oldSize, encoded[:newSize])
+	return true
+}
+
+// InsertString inserts s before the rune at index; index RuneCount appends.
+func (b *COWBuffer) InsertString(index int, s string) bool {
+	runes := b.runeIndex()
+	if index < 0 || index > len(runes) || b.data == nil || *b.refs <= 0 {
+		return false
+	}
+	offset := len(b.data)
+	if index < len(runes) {
+		offset = runes[index]
+	}
+	if len(s) != 0 {
+		b.splice(offset, offset, unsafeBytes(s))
+	}
+	return true
+}
+
+// splice replaces data[from:to] with repl in newly allocated storage.
+func (b *COWBuffer) splice(from, to int, repl []byte) {
+	newData := b.allocate(len(b.data) - (to - from) + len(repl))
+	n := copy(newData, b.data[:from])
+	n += copy(newData[n:], repl)
+	copy(newData[n:], b.data[to:])
+	b.runes = nil
+	b.replaceStorage(newData)
+}
+
+func TestCOWBufferRunes(t *testing.T) {
+	buffer := NewCOWBuffer([]byte("héllo, мир"))
+	defer buffer.Close()
+
+	assert.True(t, buffer.Valid())
+	assert.Equal(t, 10, buffer.RuneCount())
+
+	r, ok := buffer.RuneAt(1)
+	assert.True(t, ok)
+	assert.Equal(t, 'é', r)
+	r, ok = buffer.RuneAt(9)
+	assert.True(t, ok)
+	assert.Equal(t, 'р', r)
+	_, ok = buffer.RuneAt(10)
+	assert.False(t, ok)
+	_, ok = buffer.RuneAt(-1)
+	assert.False(t, ok)
+
+	invalid := NewCOWBuffer([]byte{'a', 0xff, 'b'})
+	assert.False(t, invalid.Valid())
+	assert.Equal(t, 3, invalid.RuneCount())
+	r, _ = invalid.RuneAt(1)
+	assert.Equal(t, utf8.RuneError, r)
+	invalid.Close()
+}
+
+func TestCOWBufferReplaceRune(t *testing.T) {
+	data := []byte("héllo, мир")
+	buffer := NewCOWBuffer(data)
+	defer buffer.Close()
+	clone := buffer.Clone()
+	defer clone.Close()
+
+	// same width, copy on write only
+	assert.True(t, clone.ReplaceRune(1, 'ë'))
+	assert.Equal(t, "hëllo, мир", clone.String())
+	assert.Equal(t, "héllo, мир", buffer.String())
+
+	previous := unsafe.SliceData(clone.data)
+	assert.True(t, clone.ReplaceRune(7, 'М'))
+	assert.True(t, previous == unsafe.SliceData(clone.data))
+	assert.Equal(t, "hëllo, Мир", clone.String())
+
+	// width changes rebuild the storage and the rune index
+	assert.True(t, clone.ReplaceRune(1, 'e'))
+	assert.Equal(t, "hello, Мир", clone.String())
+	assert.Equal(t, 10, clone.RuneCount())
+	r, _ := clone.RuneAt(8)
+	assert.Equal(t, 'и', r)
+
+	assert.True(t, clone.ReplaceRune(0, '日'))
+	assert.Equal(t, "日ello, Мир", clone.String())
+	r, _ = clone.RuneAt(1)
+	assert.Equal(t, 'e', r)
+
+	assert.False(t, clone.ReplaceRune(10, 'x'))
+	assert.True(t, unsafe.SliceData(data) == unsafe.SliceData(buffer.data))
+	assert.Equal(t, "héllo, мир", buffer.String())
+}
+
+func TestCOWBufferInsertString(t *testing.T) {
+	buffer := NewCOWBuffer([]byte("мир"))
+	defer buffer.Close()
+	clone := buffer.Clone()
+	defer clone.Close()
+
+	assert.Equal(t, 3, clone.RuneCount())
+	assert.True(t, clone.InsertString(0, "привет, "))
+	assert.Equal(t, "привет, мир", clone.String())
+	assert.Equal(t, 11, clone.RuneCount())
+
+	assert.True(t, clone.InsertString(11, "!"))
+	assert.True(t, clone.InsertString(6, " дивный"))
+	assert.Equal(t, "привет дивный, мир!", clone.String())
+	r, _ := clone.RuneAt(18)
+	assert.Equal(t, '!', r)
+
+	assert.True(t, clone.InsertString(3, ""))
+	assert.False(t, clone.InsertString(-1, "x"))
+	assert.False(t, clone.InsertString(100, "x"))
+
+	assert.Equal(t, "мир", buffer.String())
+	assert.Equal(t, 1, *buffer.refs)
+	assert.Equal(t, 1, *clone.refs)
+
+	// byte updates invalidate the cached index
+	ascii := NewCOWBuffer([]byte("abc"))
+	assert.Equal(t, 3, ascii.RuneCount())
+	assert.True(t, ascii.Update(0, 0xd0))
+	assert.True(t, ascii.Update(1, 0xbc))
+	assert.Equal(t, 2, ascii.RuneCount())
+	r, _ = ascii.RuneAt(0)
+	assert.Equal(t, 'м', r)
+	ascii.Close()
+
+	closed := buffer.Clone()
+	closed.Close()
+	assert.False(t, closed.InsertString(0, "x"))
+}
@@ -5,34 +5,61 @@ import (
 	"testing"
 	"unsafe"
 	"fmt"
-	"runtime"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// Allocator provides storage for copies made on write. Free receives the
+// storage back once its last reference is closed.
+type Allocator interface {
+	Alloc(size int) []byte
+	Free(data []byte)
+}
+
 type COWBuffer struct {
-	data []byte
-	refs *int
+	data  []byte
+	refs  *int
+	free  func() error // run on last release, e.g. to unmap; Close ignores its error
+	alloc Allocator    // nil means plain make
+	block []byte       // whole storage obtained from alloc, shared by views
+	stats *COWStats    // nil unless instrumented
+	runes []int        // byte offset of each rune, built lazily
+
+	readOnly bool // storage owned elsewhere, even the last owner copies
+	// private, if set, gives a writable copy of data whose pages are only
+	// copied once written, together with the func that releases it
+	private func(data []byte) ([]byte, func() error, error)
 }
 
+// NewCOWBuffer starts a buffer with one reference. Every buffer, clone and
+// view must be closed explicitly; there is no finalizer to catch a leak.
 func NewCOWBuffer(data []byte) COWBuffer {
 	var refs int = 1
-	newBuffer := COWBuffer{data, &refs}
-	runtime.SetFinalizer(&newBuffer, (*COWBuffer).Close)
-	return newBuffer
+	return COWBuffer{data: data, refs: &refs}
 }
 
 func (b *COWBuffer) Clone() COWBuffer {
 	*b.refs++
-	newBuffer := COWBuffer{b.data, b.refs}
-	runtime.SetFinalizer(&newBuffer, (*COWBuffer).Close)
-	return newBuffer
+	b.stats.recordClone(*b.refs)
+	return *b
 }
 
 func (b *COWBuffer) Close() {
 	if b.data != nil {
 		b.data = nil
-		*b.refs--
+		b.release()
+	}
+}
+
+func (b *COWBuffer) release() {
+	*b.refs--
+	if *b.refs != 0 {
+		return
+	}
+	if b.free != nil {
+		b.free()
+	} else if b.block != nil {
+		b.alloc.Free(b.block)
 	}
 }
 
@@ -40,19 +67,59 @@ func (b *COWBuffer) Update(index int, value byte) bool {
 	if index < 0 || index >= len(b.data) || *b.refs <= 0 {
 		return false
 	}
-	if *b.refs > 1 {
-		newData := make([]byte, len(b.data))
-		copy(newData, b.data)
-		*b.refs--
-		b.data = newData
-		var refs int = 1
-		b.refs = &refs
-	}
+	b.detach()
 	b.data[index] = value
 	
 	return true
 }
 
+// detach is called before every write: it gives the buffer storage of its
+// own and drops caches derived from the contents.
+func (b *COWBuffer) detach() {
+	b.runes = nil
+	if b.private != nil {
+		if data, free, err := b.private(b.data); err == nil {
+			// nothing is copied up front, written pages are copied later
+			b.stats.recordCopy(0, *b.refs)
+			b.swapStorage(data, free)
+			return
+		}
+	}
+	if *b.refs > 1 || b.readOnly {
+		newData := b.allocate(len(b.data))
+		copy(newData, b.data)
+		b.replaceStorage(newData)
+	}
+}
+
+func (b *COWBuffer) allocate(size int) []byte {
+	if b.alloc != nil {
+		return b.alloc.Alloc(size)
+	}
+	return make([]byte, size)
+}
+
+func (b *COWBuffer) replaceStorage(newData []byte) {
+	b.stats.recordCopy(len(newData), *b.refs)
+	b.swapStorage(newData, nil)
+	if b.alloc != nil {
+		b.block = newData[:cap(newData)]
+	}
+}
+
+// swapStorage releases the current storage and makes data the buffer's
+// own, with free run once its last reference is released.
+func (b *COWBuffer) swapStorage(data []byte, free func() error) {
+	b.release()
+	b.data = data
+	var refs int = 1
+	b.refs = &refs
+	b.free = free
+	b.readOnly = false
+	b.private = nil
+	b.block = nil
+}
+
 func (b *COWBuffer) String() string {
 	if len(b.data) == 0 {
 	return ""
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type InternerOption func(*Interner)
+
+// WithWeakEviction makes the interner drop strings that were not requested
+// between two consecutive Sweep calls.
+func WithWeakEviction() InternerOption {
+	return func(in *Interner) {
+		in.weak = true
+	}
+}
+
+type InternerStats struct {
+	Lookups int64
+	Hits    int64
+	Misses  int64
+	Strings int64
+	Bytes   int64
+	Evicted int64
+}
+
+// Interner deduplicates strings and byte slices into canonical strings.
+// Lookups convert byte slices with unsafe.String, so a hit costs no
+// allocation; only a miss copies the bytes once into the pool.
+type Interner struct {
+	mu      sync.RWMutex
+	current map[string]string
+	old     map[string]string
+	weak    bool
+
+	hits    atomic.Int64
+	misses  atomic.Int64
+	bytes   int64
+	evicted int64
+}
+
+func NewInterner(options ...InternerOption) *Interner {
+	in := &Interner{current: make(map[string]string)}
+	for _, option := range options {
+		option(in)
+	}
+	return in
+}
+
+func (in *Interner) Intern(s string) string {
+	return in.intern(s, false)
+}
+
+func (in *Interner) InternBytes(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return in.intern(unsafe.String(unsafe.SliceData(b), len(b)), true)
+}
+
+// intern looks key up and inserts a private copy of it on a miss. When
+// borrowed is set key aliases caller memory and must never be retained.
+func (in *Interner) intern(key string, borrowed bool) string {
+	in.mu.RLock()
+	s, ok := in.current[key]
+	in.mu.RUnlock()
+	if ok {
+		in.hits.Add(1)
+		return s
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if s, ok = in.current[key]; ok {
+		in.hits.Add(1)
+		return s
+	}
+	if s, ok = in.old[key]; ok {
+		delete(in.old, key)
+		in.current[s] = s
+		in.hits.Add(1)
+		return s
+	}
+
+	if borrowed {
+		s = string([]byte(key))
+	} else {
+		// do not pin a possibly larger string the key was sliced from
+		s = strings.Clone(key)
+	}
+	in.current[s] = s
+	in.bytes += int64(len(s))
+	in.misses.Add(1)
+	return s
+}
+
+// Sweep evicts strings not requested since the previous Sweep. It is a
+// no-op unless the interner was created with WithWeakEviction.
+func (in *Interner) Sweep() int {
+	if !in.weak {
+		return 0
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	evicted := len(in.old)
+	for s := range in.old {
+		in.bytes -= int64(len(s))
+	}
+	in.evicted += int64(evicted)
+	in.old = in.current
+	in.current = make(map[string]string, len(in.old))
+	return evicted
+}
+
+func (in *Interner) Len() int {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	return len(in.current) + len(in.old)
+}
+
+func (in *Interner) Stats() InternerStats {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	hits, misses := in.hits.Load(), in.misses.Load()
+	return InternerStats{
+		Lookups: hits + misses,
+		Hits:    hits,
+		Misses:  misses,
+		Strings: int64(len(in.current) + len(in.old)),
+		Bytes:   in.bytes,
+		Evicted: in.evicted,
+	}
+}
+
+func TestInterner(t *testing.T) {
+	interner := NewInterner()
+
+	raw := []byte("user_id")
+	first := interner.InternBytes(raw)
+	assert.Equal(t, "user_id", first)
+	assert.True(t, unsafe.StringData(first) != unsafe.SliceData(raw))
+
+	// the pool owns its copy, caller memory may be reused
+	raw[0] = 'U'
+	assert.Equal(t, "user_id", first)
+
+	second := interner.Intern(strings.ToLower("USER_ID"))
+	assert.True(t, unsafe.StringData(first) == unsafe.StringData(second))
+
+	third := interner.InternBytes([]byte("user_id"))
+	assert.True(t, unsafe.StringData(first) == unsafe.StringData(third))
+
+	long := "request_id=42"
+	key := interner.Intern(long[:10])
+	assert.Equal(t, "request_id", key)
+	assert.True(t, unsafe.StringData(key) != unsafe.StringData(long))
+
+	assert.Equal(t, "", interner.InternBytes(nil))
+	assert.Equal(t, 2, interner.Len())
+	assert.Equal(t, InternerStats{
+		Lookups: 4,
+		Hits:    2,
+		Misses:  2,
+		Strings: 2,
+		Bytes:   int64(len("user_id") + len("request_id")),
+	}, interner.Stats())
+
+	// eviction is opt-in
+	assert.Equal(t, 0, interner.Sweep())
+	assert.Equal(t, 0, interner.Sweep())
+	assert.Equal(t, 2, interner.Len())
+}
+
+func TestInternerWeakEviction(t *testing.T) {
+	interner := NewInterner(WithWeakEviction())
+
+	hot := interner.Intern("hot")
+	interner.Intern("cold")
+
+	assert.Equal(t, 0, interner.Sweep())
+	assert.Equal(t, 2, interner.Len())
+
+	// hot is promoted back to the current generation
+	assert.True(t, unsafe.StringData(hot) == unsafe.StringData(interner.Intern("hot")))
+
+	assert.Equal(t, 1, interner.Sweep())
+	assert.Equal(t, 1, interner.Len())
+
+	stats := interner.Stats()
+	assert.Equal(t, int64(1), stats.Evicted)
+	assert.Equal(t, int64(len("hot")), stats.Bytes)
+
+	assert.Equal(t, 1, interner.Sweep())
+	assert.Equal(t, 0, interner.Len())
+	assert.Equal(t, int64(0), interner.Stats().Bytes)
+}
+
+func TestInternerConcurrent(t *testing.T) {
+	const workers = 8
+	const keys = 100
+
+	interner := NewInterner(WithWeakEviction())
+	internAll := func(sweep bool) [][]string {
+		results := make([][]string, workers)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				results[w] = make([]string, keys)
+				for i := 0; i < keys; i++ {
+					results[w][i] = interner.InternBytes([]byte("key-" + strconv.Itoa(i)))
+					if sweep && i%25 == 0 && w == 0 {
+						interner.Sweep()
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		return results
+	}
+
+	// sweeps may evict a key between workers, so only the values match
+	for w, values := range internAll(true) {
+		for i, value := range values {
+			assert.Equal(t, "key-"+strconv.Itoa(i), value, "worker %d", w)
+		}
+	}
+
+	// without sweeps every worker gets the canonical string
+	results := internAll(false)
+	for i := 0; i < keys; i++ {
+		canonical := interner.Intern("key-" + strconv.Itoa(i))
+		assert.Equal(t, "key-"+strconv.Itoa(i), canonical)
+		for w := 0; w < workers; w++ {
+			assert.True(t, unsafe.StringData(canonical) == unsafe.StringData(results[w][i]), "worker %d key %d", w, i)
+		}
+	}
+	stats := interner.Stats()
+	assert.Equal(t, int64(2*workers*keys+keys), stats.Lookups)
+	assert.Equal(t, stats.Lookups, stats.Hits+stats.Misses)
+}